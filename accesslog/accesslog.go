@@ -8,6 +8,7 @@ import (
 	"os"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ochipin/logger"
@@ -15,10 +16,45 @@ import (
 
 var environRegex = regexp.MustCompile(`%\{(.+?)\}[iec]`)
 
+// logfmt 形式で使用する指定子
+var matchDirective = regexp.MustCompile(`%\{(.+?)\}[iec]|%[a-z]{2}`)
+
+// Encoding に指定できるログの形式
+const (
+	EncodingText   = "text"   // Format に沿って出力する
+	EncodingLogfmt = "logfmt" // Format の指定子を、logfmt 形式の key=value で出力する
+)
+
+// 指定子に対応する、logfmt 形式のキー
+var directiveKeys = map[string]string{
+	"%ra": "remote_addr",
+	"%sa": "server_addr",
+	"%cl": "content_length",
+	"%et": "elapsed",
+	"%fn": "files",
+	"%rh": "host",
+	"%rp": "proto",
+	"%rm": "method",
+	"%vp": "port",
+	"%qp": "query",
+	"%st": "status",
+	"%at": "time",
+	"%au": "user",
+	"%up": "path",
+	"%vh": "server_name",
+	"%ua": "user_agent",
+	"%mn": "mode",
+	"%pn": "scheme",
+	"%xf": "forwarded_for",
+	"%rf": "referer",
+}
+
 // Log 構造体は、ログ情報を取り扱う構造体
 type Log struct {
+	mu sync.Mutex
 	logger.Log
 	Format   string // ログフォーマット
+	Encoding string // ログの形式。"logfmt" の場合は、Format の指定子を key=value で出力する。空文字列の場合は "text" とする
 	Modename string // 起動モード名
 	ServerIP string // サーバのIPアドレス
 	hostname string // ホスト名
@@ -31,6 +67,9 @@ type Logger interface {
 
 // MakeLog 関数はログ管理構造体を初期化する
 func (l *Log) MakeLog(out *os.File) (Logger, error) {
+	if err := checkEncoding(l.Encoding); err != nil {
+		return nil, err
+	}
 	if err := l.Initializer(out); err != nil {
 		return nil, err
 	}
@@ -49,10 +88,43 @@ func (l *Log) MakeLog(out *os.File) (Logger, error) {
 
 // Print 関数はログを出力する
 func (l *Log) Print(status int, start time.Time, r *http.Request) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	info := l.message(status, start, r)
 	l.Log.Print(info, "\n")
 }
 
+// Apply 関数は、動作中のログ管理構造体へ、src にセットされたパラメータを反映する。
+// MakeLog と同様に、全て1行で出力することを強制するため、src の Newline, Trim, Tabspace は true に変更される
+func (l *Log) Apply(src *Log) error {
+	if err := checkEncoding(src.Encoding); err != nil {
+		return err
+	}
+	src.Newline = true
+	src.Trim = true
+	src.Tabspace = true
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.Log.Apply(&src.Log); err != nil {
+		return err
+	}
+	l.Format = src.Format
+	l.Encoding = src.Encoding
+	l.Modename = src.Modename
+	l.ServerIP = src.ServerIP
+	return nil
+}
+
+// Encoding に指定された値を検証する
+func checkEncoding(encoding string) error {
+	switch encoding {
+	case "", EncodingText, EncodingLogfmt:
+		return nil
+	}
+	return fmt.Errorf("\"%s\" encoding is not supported", encoding)
+}
+
 // 認証ユーザ名を取得する
 func (l *Log) username(au string) string {
 	if au == "" {
@@ -75,15 +147,65 @@ func (l *Log) username(au string) string {
 
 // 出力するログ情報を選定する
 func (l *Log) message(status int, start time.Time, r *http.Request) string {
+	values := l.values(status, start, r)
+	if l.Encoding == EncodingLogfmt {
+		return l.logfmt(values, start, r)
+	}
+	rep := strings.NewReplacer(values...)
 	formats := strings.Split(l.Format, "%%")
 	for i := 0; i < len(formats); i++ {
-		formats[i] = l.getinfo(formats[i], status, start, r)
+		formats[i] = l.getinfo(rep, formats[i], r)
 	}
 	return strings.Join(formats, "%")
 }
 
+// Format の指定子を、出現順に logfmt 形式の key=value へ変換する。指定子以外の文字列は出力しない。
+// %at は RFC 3339 形式とし、%{Name}i, %{Name}e, %{Name}c は Name をキーとする
+// ex) "%ra - %au [%at] \"%rm %up %rp\" %st" ---> remote_addr=192.168.1.2 user=- time=2018-03-21T21:22:02+09:00 method=GET path=/ proto=HTTP/1.1 status=200
+func (l *Log) logfmt(values []string, start time.Time, r *http.Request) string {
+	var b []byte
+	for _, d := range matchDirective.FindAllString(strings.Replace(l.Format, "%%", "", -1), -1) {
+		if d[1] == '{' {
+			// ヘッダ名は小文字とし、"-" を "_" に置き換える ex) %{X-Request-Id}i ---> x_request_id
+			key := d[2 : len(d)-2]
+			if d[len(d)-1] == 'i' {
+				key = strings.Replace(strings.ToLower(key), "-", "_", -1)
+			}
+			b = logger.AppendLogfmt(b, key, l.environ(d, r))
+			continue
+		}
+		key, ok := directiveKeys[d]
+		if !ok {
+			continue
+		}
+		if d == "%at" {
+			b = logger.AppendLogfmt(b, key, start.Format(time.RFC3339Nano))
+			continue
+		}
+		for i := 0; i < len(values); i += 2 {
+			if values[i] == d {
+				b = logger.AppendLogfmt(b, key, values[i+1])
+				break
+			}
+		}
+	}
+	return string(b)
+}
+
 // 出力するログ情報を整理する
-func (l *Log) getinfo(format string, status int, start time.Time, r *http.Request) string {
+func (l *Log) getinfo(rep *strings.Replacer, format string, r *http.Request) string {
+	info := rep.Replace(format)
+
+	// リクエストヘッダ/環境変数/クッキーから情報の取得を行う
+	info = environRegex.ReplaceAllStringFunc(info, func(str string) string {
+		return l.environ(str, r)
+	})
+	// ログに出力する情報を返却する
+	return info
+}
+
+// 指定子と、置き換える値を交互に並べて返却する
+func (l *Log) values(status int, start time.Time, r *http.Request) []string {
 	// アクセス元のIPアドレスを取得する
 	ra, _, _ := net.SplitHostPort(r.RemoteAddr)
 	// サーバのIPアドレスを取得する
@@ -153,8 +275,8 @@ func (l *Log) getinfo(format string, status int, start time.Time, r *http.Reques
 	}
 	// 処理時間を取得する
 	et := fmt.Sprint(time.Since(start).String())
-	// 取得した値で、フォーマットを置き換える
-	rep := strings.NewReplacer(
+	// 指定子と取得した値
+	return []string{
 		"%ra", ra, // 訪問者(ユーザ)のIPアドレス
 		"%sa", sa, // サーバのIPアドレス
 		"%cl", cl, // 送信バイト数(Byte)
@@ -175,38 +297,34 @@ func (l *Log) getinfo(format string, status int, start time.Time, r *http.Reques
 		"%pn", pn, // プロトコル名
 		"%xf", xf, // リバースプロキシ使用時のリアルIP
 		"%rf", rf, // リファラ
-	)
-	info := rep.Replace(format)
+	}
+}
 
-	// リクエストヘッダ/環境変数/クッキーから情報の取得を行う
-	info = environRegex.ReplaceAllStringFunc(info, func(str string) string {
-		r := r
-		length := len(str)
-		c := str[length-1]
-		var result string
-		if c == 'i' {
-			// リクエストヘッダから情報を取得
-			name := str[2 : length-2]
-			if name == "Authorization" {
-				result = l.username(r.Header.Get("Authorization"))
-			} else {
-				result = r.Header.Get(name)
-			}
-		} else if c == 'e' {
-			// 環境変数から情報を取得
-			result = os.Getenv(str[2 : length-2])
-		} else if c == 'c' {
-			// クッキーから情報を取得
-			if v, err := r.Cookie(str[2 : length-2]); err == nil {
-				result = v.Value
-			}
+// %{Name}i, %{Name}e, %{Name}c の値を、リクエストヘッダ/環境変数/クッキーから取得する
+func (l *Log) environ(str string, r *http.Request) string {
+	length := len(str)
+	c := str[length-1]
+	var result string
+	if c == 'i' {
+		// リクエストヘッダから情報を取得
+		name := str[2 : length-2]
+		if name == "Authorization" {
+			result = l.username(r.Header.Get("Authorization"))
+		} else {
+			result = r.Header.Get(name)
 		}
-		// 取得した情報が空の場合、 "-" を格納する
-		if result == "" {
-			result = "-"
+	} else if c == 'e' {
+		// 環境変数から情報を取得
+		result = os.Getenv(str[2 : length-2])
+	} else if c == 'c' {
+		// クッキーから情報を取得
+		if v, err := r.Cookie(str[2 : length-2]); err == nil {
+			result = v.Value
 		}
-		return result
-	})
-	// ログに出力する情報を返却する
-	return info
+	}
+	// 取得した情報が空の場合、 "-" を格納する
+	if result == "" {
+		result = "-"
+	}
+	return result
 }
@@ -30,3 +30,23 @@ func TestAccessLog(t *testing.T) {
 		t.Fatalf("Error by http.Get(). %v", err)
 	}
 }
+
+func TestLogfmt(t *testing.T) {
+	log := &Log{}
+	log.Format = "%ra - %au [%at] \"%rm %up%qp %rp\" %st %%{X-Request-Id}i %{X-Request-Id}i %ua"
+	log.Encoding = EncodingLogfmt
+
+	r := httptest.NewRequest("GET", "/index.html?q=a+b", nil)
+	r.RemoteAddr = "192.168.1.2:54321"
+	r.Header.Set("X-Request-Id", "abc")
+	r.Header.Set("User-Agent", "Mozilla/5.0 (X11)")
+	start := time.Date(2018, 3, 21, 21, 22, 2, 0, time.UTC)
+
+	want := `remote_addr=192.168.1.2 user=- time=2018-03-21T21:22:02Z method=GET path=/index.html query="?q=a+b" proto=HTTP/1.1 status=200 x_request_id=abc user_agent="Mozilla/5.0 (X11)"`
+	if s := log.message(200, start, r); s != want {
+		t.Fatal(s)
+	}
+	if err := log.Apply(&Log{Encoding: "json"}); err == nil {
+		t.Fatal("unsupported encoding was accepted")
+	}
+}
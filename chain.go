@@ -0,0 +1,247 @@
+package logger
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ハッシュチェインの接尾辞、チェックポイント、ジェネシス行の書式
+const (
+	chainSuffix  = " chain:"        // 各行の末尾へ付与するハッシュ値の接頭辞
+	chainExt     = ".chain"         // ハッシュ値を書き込むサイドカーファイルの拡張子
+	chainCheck   = "#checkpoint "   // ログローテーション時に、アーカイブの末尾へ書き込む行
+	chainGenesis = "#genesis prev=" // ログローテーション時に、ログファイルの先頭へ書き込む行
+	chainHexLen  = sha256.Size * 2  // ハッシュ値の16進数表記の長さ
+)
+
+// ChainError 構造体は、ハッシュチェインが途切れた行を取り扱う構造体
+type ChainError struct {
+	Path   string // 検証したログファイル
+	Line   int    // ハッシュチェインが途切れた行番号(1始まり)
+	Reason string // 途切れた理由
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("logger: %s:%d: hash chain is broken: %s", e.Path, e.Line, e.Reason)
+}
+
+// 直前のハッシュ値と1行のログから、次のハッシュ値を計算する
+func chainHash(key, prev []byte, s string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(prev)
+	mac.Write([]byte(s))
+	return mac.Sum(nil)
+}
+
+// ログファイルの末尾から、直前のハッシュ値を読み込む。l.mu をロックした状態で呼び出すこと
+func (l *Log) loadChain() {
+	if l.chainLoaded {
+		return
+	}
+	l.chainLoaded = true
+	l.chainPrev = make([]byte, sha256.Size)
+
+	path := l.Path
+	if l.HashSidecar {
+		path += chainExt
+	}
+	line := lastLine(path)
+	if !l.HashSidecar {
+		if i := strings.LastIndex(line, chainSuffix); i != -1 {
+			line = line[i+len(chainSuffix):]
+		}
+	}
+	if i := strings.Index(line, " "); i != -1 {
+		line = line[:i]
+	}
+	if h, err := hex.DecodeString(line); err == nil && len(h) == sha256.Size {
+		l.chainPrev = h
+	}
+}
+
+// ファイルの最終行を取得する。最終行は4096バイト以内である前提とする
+func lastLine(path string) string {
+	fp, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer fp.Close()
+	info, err := fp.Stat()
+	if err != nil {
+		return ""
+	}
+	off := info.Size() - 4096
+	if off < 0 {
+		off = 0
+	}
+	buf := make([]byte, info.Size()-off)
+	if _, err := fp.ReadAt(buf, off); err != nil && err != io.EOF {
+		return ""
+	}
+	s := strings.TrimSuffix(string(buf), "\n")
+	return s[strings.LastIndex(s, "\n")+1:]
+}
+
+// ハッシュ値を付与して、ログを指定されたファイルへ書き込む。書き込めた場合のみ、直前のハッシュ値を更新する。
+// l.mu をロックした状態で呼び出すこと
+func (l *Log) writeChain(path, s string) error {
+	l.loadChain()
+	h := chainHash(l.HashKey, l.chainPrev, s)
+	sum := hex.EncodeToString(h)
+	if !l.HashSidecar {
+		if err := l.writefile(path, s+chainSuffix+sum); err != nil {
+			return err
+		}
+		l.chainPrev = h
+		return nil
+	}
+	if err := l.writefile(path, s); err != nil {
+		return err
+	}
+	// サイドカーファイルには、ハッシュ値と、ログの物理行数を書き込む
+	if err := l.writefile(path+chainExt, sum+" "+strconv.Itoa(strings.Count(s, "\n")+1)); err != nil {
+		return err
+	}
+	l.chainPrev = h
+	return nil
+}
+
+// ローテーション後のアーカイブへチェックポイントを書き込み、ログファイルの先頭へジェネシス行を書き込む。
+// ログファイルの内容をアーカイブへ移動した後に、l.mu をロックした状態で呼び出すこと
+func (l *Log) checkpoint(lotatepath string, now time.Time) error {
+	if err := l.writeChain(lotatepath, chainCheck+now.Format(time.RFC3339)); err != nil {
+		return err
+	}
+	return l.writeChain(l.Path, chainGenesis+hex.EncodeToString(l.chainPrev))
+}
+
+// VerifyChain : HashKey を指定して出力したログファイルのハッシュチェインを検証する。
+// ハッシュチェインが途切れている場合は、途切れた行を *ChainError で返却する。
+// gzip 圧縮されたアーカイブ(.gz)も検証でき、サイドカーファイル(<ログファイル>.chain)が存在する場合は、サイドカーファイルのハッシュ値で検証する
+func VerifyChain(path string, key []byte) error {
+	fp, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	var r io.Reader = fp
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(fp)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	v := &chainVerifier{path: path, key: key, prev: make([]byte, sha256.Size)}
+	sidecar := strings.TrimSuffix(path, ".gz") + chainExt
+	if sc, err := os.Open(sidecar); err == nil {
+		defer sc.Close()
+		v.sidecar = bufio.NewScanner(sc)
+		v.sidecar.Buffer(nil, 1024*1024)
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		if err := v.line(scanner.Text()); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return v.end()
+}
+
+// ハッシュチェインを1行ずつ検証する
+type chainVerifier struct {
+	path    string
+	key     []byte
+	prev    []byte
+	sidecar *bufio.Scanner
+	lineno  int      // 読み込んだ物理行数
+	start   int      // 検証中のログの開始行番号
+	lines   []string // 検証中のログ。改行を含むログは複数行となる
+	want    int      // サイドカーファイルに記録された、検証中のログの物理行数
+	sum     string   // 検証中のログのハッシュ値
+}
+
+func (v *chainVerifier) broken(reason string) error {
+	return &ChainError{Path: v.path, Line: v.start, Reason: reason}
+}
+
+// 1行を読み込み、ログの最終行であれば検証する
+func (v *chainVerifier) line(s string) error {
+	v.lineno++
+	if len(v.lines) == 0 {
+		v.start = v.lineno
+		if v.sidecar != nil {
+			if !v.sidecar.Scan() {
+				return v.broken("hash is not found in sidecar file")
+			}
+			fields := strings.Fields(v.sidecar.Text())
+			n := 1
+			if len(fields) == 2 {
+				n, _ = strconv.Atoi(fields[1])
+			}
+			if len(fields) == 0 || n < 1 {
+				return v.broken("sidecar file is malformed")
+			}
+			v.sum, v.want = fields[0], n
+		}
+	}
+	v.lines = append(v.lines, s)
+
+	var record string
+	if v.sidecar != nil {
+		if len(v.lines) < v.want {
+			return nil
+		}
+		record = strings.Join(v.lines, "\n")
+	} else {
+		// 末尾にハッシュ値が無い行は、改行を含むログの途中とみなす
+		i := strings.LastIndex(s, chainSuffix)
+		if i == -1 || len(s)-i-len(chainSuffix) != chainHexLen {
+			return nil
+		}
+		v.lines[len(v.lines)-1], v.sum = s[:i], s[i+len(chainSuffix):]
+		record = strings.Join(v.lines, "\n")
+	}
+	v.lines = nil
+
+	// ファイル先頭のジェネシス行は、前のファイルの最終ハッシュ値を起点とする
+	if v.start == 1 && strings.HasPrefix(record, chainGenesis) {
+		if h, err := hex.DecodeString(record[len(chainGenesis):]); err == nil && len(h) == sha256.Size {
+			v.prev = h
+		}
+	}
+	h := chainHash(v.key, v.prev, record)
+	if !hmac.Equal([]byte(hex.EncodeToString(h)), []byte(v.sum)) {
+		return v.broken("hash mismatch")
+	}
+	v.prev = h
+	return nil
+}
+
+// 全ての行を読み込んだ後に、ハッシュ値の無い行、サイドカーファイルの余剰が無いか検証する
+func (v *chainVerifier) end() error {
+	if len(v.lines) != 0 {
+		return v.broken("hash is not found")
+	}
+	if v.sidecar != nil && v.sidecar.Scan() {
+		v.start = v.lineno + 1
+		return v.broken("log lines are missing")
+	}
+	return nil
+}
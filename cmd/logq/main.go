@@ -0,0 +1,348 @@
+// logq は、logger の設定ファイルから Lotate を展開してアーカイブを列挙し、指定した期間のログを出力するコマンド
+//
+//	logq -config app.json -section errorlog -from "2018-03-21 10:00" -to "2018-03-21 11:00" -level warn
+//	logq -config app.json -section accesslog -from 2h -status 5xx -grep '/api/'
+//	logq -config app.json -key 2018=/etc/app/log.key
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ochipin/logger"
+	"github.com/ochipin/logger/accesslog"
+	"github.com/ochipin/logger/config"
+	"github.com/ochipin/logger/errorlog"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// Query 構造体は、出力するログの条件を取り扱う構造体
+type Query struct {
+	From   time.Time      // この日時以降のログを出力する。ゼロ値の場合は制限しない
+	To     time.Time      // この日時より前のログを出力する。ゼロ値の場合は制限しない
+	Level  int            // このログレベル以上に重大なログを出力する。-1 の場合は制限しない
+	Status func(int) bool // 出力する接続ステータス。nil の場合は制限しない
+	Grep   *regexp.Regexp // 出力するログの正規表現。nil の場合は制限しない
+	Parser *Parser        // ログの解析に使用する Parser。nil の場合は日時等の条件を判定しない
+	match  bool           // 直前の行を出力したか否か。Format に一致しない行は、直前の行に従う
+}
+
+// Match : 1行を出力するか判定する
+func (q *Query) Match(line string) bool {
+	if q.Parser != nil {
+		rec, ok := q.Parser.Parse(line)
+		if !ok {
+			// 改行を含むログの2行目以降等は、直前の行に従う
+			return q.match && (q.Grep == nil || q.Grep.MatchString(line))
+		}
+		q.match = q.record(rec)
+		if !q.match {
+			return false
+		}
+	}
+	return q.Grep == nil || q.Grep.MatchString(line)
+}
+
+// 日時、ログレベル、接続ステータスの条件を判定する
+func (q *Query) record(rec Record) bool {
+	if !rec.Time.IsZero() {
+		if !q.From.IsZero() && rec.Time.Before(q.From) {
+			return false
+		}
+		if !q.To.IsZero() && !rec.Time.Before(q.To) {
+			return false
+		}
+	}
+	if q.Level >= 0 && (rec.Level < 0 || rec.Level > q.Level) {
+		return false
+	}
+	if q.Status != nil && !q.Status(rec.Status) {
+		return false
+	}
+	return true
+}
+
+// コマンドを実行し、終了コードを返却する
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("logq", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	var (
+		path    = flags.String("config", "", "logger config file (json/yaml/toml)")
+		section = flags.String("section", "", "config section: logger, errorlog or accesslog (default: the first configured one)")
+		from    = flags.String("from", "", `start time: "2006-01-02 15:04:05", "2006-01-02", RFC3339 or duration ago like "2h"`)
+		to      = flags.String("to", "", "end time (exclusive), same formats as -from")
+		level   = flags.String("level", "", "errorlog: print logs at this level or more severe")
+		status  = flags.String("status", "", `accesslog: status filter like "500", "5xx" or "400-499"`)
+		grep    = flags.String("grep", "", "print lines matching the regular expression")
+		list    = flags.Bool("list", false, "list log files instead of printing logs")
+		keys    = &keyFlag{}
+	)
+	flags.Var(keys, "key", "decryption key of encrypted archives as id=file (repeatable)")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *path == "" {
+		fmt.Fprintln(stderr, "logq: -config is required")
+		flags.Usage()
+		return 2
+	}
+
+	log, q, err := setup(*path, *section)
+	if err == nil {
+		err = q.parse(*from, *to, *level, *status, *grep)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	// 期間外のアーカイブは読み込まない。マニフェストに記録されたアーカイブは、記録された日時で判定する
+	var manifest *logger.Manifest
+	if log.ManifestPath != "" {
+		if manifest, err = logger.ReadManifest(log.ManifestPath); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintln(stderr, "logq:", err)
+		}
+	}
+	files := append(log.Archives(), log.Path)
+	code := 0
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			if file != log.Path {
+				fmt.Fprintln(stderr, "logq:", err)
+				code = 1
+			}
+			continue
+		}
+		if seg, ok := lookup(manifest, file); ok {
+			if !seg.Covers(q.From, q.To) {
+				continue
+			}
+		} else if !q.From.IsZero() && info.ModTime().Before(q.From) {
+			continue
+		}
+		if *list {
+			fmt.Fprintln(stdout, file)
+			continue
+		}
+		if strings.HasSuffix(file, ".enc") && len(keys.Keys) == 0 {
+			fmt.Fprintf(stderr, "logq: %s: encrypted archive requires -key\n", file)
+			code = 1
+			continue
+		}
+		if err := q.print(file, keys, stdout); err != nil {
+			fmt.Fprintln(stderr, "logq:", err)
+			code = 1
+		}
+	}
+	return code
+}
+
+// keyFlag 構造体は、-key に指定した鍵IDと鍵ファイルから、暗号化したアーカイブの復号に使用する鍵を取り扱う構造体
+type keyFlag struct {
+	logger.StaticKeys
+}
+
+// String : 指定された鍵IDを返却する
+func (k *keyFlag) String() string {
+	var ids []string
+	for id := range k.Keys {
+		ids = append(ids, id)
+	}
+	return strings.Join(ids, ",")
+}
+
+// Set : "id=file" 形式の値から鍵を読み込む。鍵ファイルには、32バイトの鍵、または16進数で表記した鍵を記述する
+func (k *keyFlag) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i <= 0 || i == len(s)-1 {
+		return fmt.Errorf("key \"%s\" must be id=file", s)
+	}
+	buf, err := ioutil.ReadFile(s[i+1:])
+	if err != nil {
+		return err
+	}
+	key := buf
+	if text := strings.TrimSpace(string(buf)); len(text) == 64 {
+		if key, err = hex.DecodeString(text); err != nil {
+			return fmt.Errorf("%s: %v", s[i+1:], err)
+		}
+	}
+	if len(key) != 32 {
+		return fmt.Errorf("%s: key must be 32 bytes", s[i+1:])
+	}
+	if k.Keys == nil {
+		k.Keys = map[string][]byte{}
+	}
+	k.Keys[s[:i]] = key
+	return nil
+}
+
+// マニフェストから、アーカイブの情報を取得する
+func lookup(m *logger.Manifest, path string) (logger.Segment, bool) {
+	if m == nil {
+		return logger.Segment{}, false
+	}
+	return m.Lookup(path)
+}
+
+// 設定ファイルを読み込み、ログ管理構造体と Query を生成する
+func setup(path, section string) (*logger.Log, *Query, error) {
+	conf, err := config.Load(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if section == "" {
+		switch {
+		case conf.Errorlog != nil:
+			section = "errorlog"
+		case conf.Accesslog != nil:
+			section = "accesslog"
+		default:
+			section = "logger"
+		}
+	}
+
+	q := &Query{Level: -1}
+	switch section {
+	case "logger":
+		if conf.Logger != nil {
+			log, err := conf.Logger.Build()
+			return log, q, err
+		}
+	case "errorlog":
+		if conf.Errorlog != nil {
+			log, err := conf.Errorlog.Build()
+			if err != nil {
+				return nil, nil, err
+			}
+			switch log.Encoding {
+			case "", errorlog.EncodingText:
+				q.Parser, err = NewErrorlogParser(log.Format)
+			case errorlog.EncodingJSON:
+				q.Parser = NewJSONParser()
+			case errorlog.EncodingLogfmt:
+				q.Parser = NewLogfmtParser()
+			default:
+				err = fmt.Errorf("logq: errorlog encoding \"%s\" is not supported", log.Encoding)
+			}
+			return &log.Log, q, err
+		}
+	case "accesslog":
+		if conf.Accesslog != nil {
+			log, err := conf.Accesslog.Build()
+			if err != nil {
+				return nil, nil, err
+			}
+			switch log.Encoding {
+			case "", accesslog.EncodingText:
+				q.Parser, err = NewAccesslogParser(log.Format)
+			case accesslog.EncodingLogfmt:
+				q.Parser = NewLogfmtParser()
+			default:
+				err = fmt.Errorf("logq: accesslog encoding \"%s\" is not supported", log.Encoding)
+			}
+			return &log.Log, q, err
+		}
+	default:
+		return nil, nil, fmt.Errorf("logq: section \"%s\" is invalid", section)
+	}
+	return nil, nil, fmt.Errorf("logq: section \"%s\" is not found in %s", section, path)
+}
+
+// コマンドライン引数から、出力するログの条件を設定する
+func (q *Query) parse(from, to, level, status, grep string) error {
+	var err error
+	if q.From, err = parseTime(from); err != nil {
+		return err
+	}
+	if q.To, err = parseTime(to); err != nil {
+		return err
+	}
+	if level != "" {
+		if q.Level, err = errorlog.ParseLevel(level); err != nil {
+			return fmt.Errorf("logq: %v", err)
+		}
+	}
+	if status != "" {
+		if q.Status, err = parseStatus(status); err != nil {
+			return err
+		}
+	}
+	if grep != "" {
+		if q.Grep, err = regexp.Compile(grep); err != nil {
+			return fmt.Errorf("logq: %v", err)
+		}
+	}
+	if q.Parser == nil && (!q.From.IsZero() || !q.To.IsZero() || q.Level >= 0 || q.Status != nil) {
+		return fmt.Errorf("logq: -from, -to, -level and -status require errorlog or accesslog section")
+	}
+	return nil
+}
+
+// 日時を解析する。"2h" 等の期間を指定した場合は、現在からその期間前の日時とする
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("logq: time \"%s\" is invalid", s)
+}
+
+// 接続ステータスの条件を解析する ex) 500, 5xx, 400-499
+func parseStatus(s string) (func(int) bool, error) {
+	if len(s) == 3 && strings.HasSuffix(strings.ToLower(s), "xx") && s[0] >= '1' && s[0] <= '5' {
+		class := int(s[0]-'0') * 100
+		return func(st int) bool { return st >= class && st < class+100 }, nil
+	}
+	lo, hi := s, s
+	if i := strings.Index(s, "-"); i != -1 {
+		lo, hi = s[:i], s[i+1:]
+	}
+	min, err1 := strconv.Atoi(lo)
+	max, err2 := strconv.Atoi(hi)
+	if err1 != nil || err2 != nil || min > max {
+		return nil, fmt.Errorf("logq: status \"%s\" is invalid", s)
+	}
+	return func(st int) bool { return st >= min && st <= max }, nil
+}
+
+// ログファイルを読み込み、条件に一致する行を出力する
+func (q *Query) print(path string, keys logger.KeyProvider, w io.Writer) error {
+	r, err := logger.OpenArchive(path, keys)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	q.match = false
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		if line := scanner.Text(); q.Match(line) {
+			fmt.Fprintln(w, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ochipin/logger"
+)
+
+// Format から生成した Parser による解析
+func TestParser(t *testing.T) {
+	p, err := NewErrorlogParser("%D %T %b[%p]: %f(%m:%l) %L: %M")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, ok := p.Parse("2018-03-21 21:22:02 app[123]: main.go(main:11) warn: a.out not found")
+	if !ok || rec.Level != 4 || rec.Time.Format("2006-01-02 15:04:05") != "2018-03-21 21:22:02" {
+		t.Fatal(rec, ok)
+	}
+	if _, ok := p.Parse("continuation line"); ok {
+		t.Fatal("continuation line is parsed")
+	}
+
+	p, err = NewAccesslogParser(`%ra - [%at] "%rm %up%qp %rp" %st %{User-Agent}i 100%%`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, ok = p.Parse(`192.168.1.3 - [2018-03-21 21:22:02] "GET /index.html HTTP/1.1" 404 curl/7.0 100%`)
+	if !ok || rec.Status != 404 || rec.Time.Format("2006-01-02 15:04:05") != "2018-03-21 21:22:02" {
+		t.Fatal(rec, ok)
+	}
+
+	p = NewLogfmtParser()
+	rec, ok = p.Parse(`time=2018-03-21T21:22:02+09:00 level=warn msg="a.out not found" status=404`)
+	if !ok || rec.Level != 4 || rec.Status != 404 || rec.Time.Unix() != 1521634922 {
+		t.Fatal(rec, ok)
+	}
+	for _, line := range []string{"continuation line", `msg="unterminated`, ""} {
+		if _, ok := p.Parse(line); ok {
+			t.Fatal(line)
+		}
+	}
+}
+
+// 接続ステータスの条件
+func TestParseStatus(t *testing.T) {
+	cases := map[string][]int{"5xx": {500, 599}, "404": {404, 404}, "400-499": {400, 499}}
+	for s, r := range cases {
+		f, err := parseStatus(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !f(r[0]) || !f(r[1]) || f(r[0]-1) || f(r[1]+1) {
+			t.Fatal(s)
+		}
+	}
+	if _, err := parseStatus("abc"); err == nil {
+		t.Fatal("invalid status is parsed")
+	}
+}
+
+// アーカイブを跨いだ期間、ログレベル、正規表現による抽出
+func TestRun(t *testing.T) {
+	os.RemoveAll("test")
+	os.MkdirAll("test/201803", 0755)
+	ioutil.WriteFile("test/config.json", []byte(`{
+		"errorlog": {"path": "test/error.log", "lotate": "test/%Y%m/error-%Y%m%d.log", "timing": "00:00", "format": "%D %T %L: %M"}
+	}`), 0644)
+	ioutil.WriteFile("test/201803/error-20180320.log", []byte(
+		"2018-03-20 23:59:59 error: old\n"), 0644)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte("2018-03-21 10:00:00 error: disk full\n  at main.go\n2018-03-21 10:30:00 info: started\n"))
+	zw.Close()
+	ioutil.WriteFile("test/201803/error-20180321.log.gz", gz.Bytes(), 0644)
+	ioutil.WriteFile("test/error.log", []byte(
+		"2018-03-22 09:00:00 crit: disk full again\n2018-03-22 12:00:00 warn: late\n"), 0644)
+	past := time.Date(2018, 3, 21, 0, 0, 0, 0, time.Local)
+	os.Chtimes("test/201803/error-20180320.log", past, past)
+	os.Chtimes("test/201803/error-20180321.log.gz", past.Add(24*time.Hour), past.Add(24*time.Hour))
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-config", "test/config.json", "-from", "2018-03-21", "-to", "2018-03-22 10:00", "-level", "error"}, &stdout, &stderr)
+	want := "2018-03-21 10:00:00 error: disk full\n  at main.go\n2018-03-22 09:00:00 crit: disk full again\n"
+	if code != 0 || stdout.String() != want {
+		t.Fatalf("%d: %q %s", code, stdout.String(), stderr.String())
+	}
+
+	stdout.Reset()
+	code = run([]string{"-config", "test/config.json", "-grep", "again|old"}, &stdout, &stderr)
+	want = "2018-03-20 23:59:59 error: old\n2018-03-22 09:00:00 crit: disk full again\n"
+	if code != 0 || stdout.String() != want {
+		t.Fatalf("%d: %q %s", code, stdout.String(), stderr.String())
+	}
+
+	stdout.Reset()
+	code = run([]string{"-config", "test/config.json", "-list"}, &stdout, &stderr)
+	if code != 0 || !strings.HasSuffix(stdout.String(), "error-20180321.log.gz\ntest/error.log\n") {
+		t.Fatalf("%d: %q %s", code, stdout.String(), stderr.String())
+	}
+
+	// JSON 形式のログは、time, level キーから判定する
+	ioutil.WriteFile("test/json.json", []byte(`{
+		"errorlog": {"path": "test/json.log", "encoding": "json"}
+	}`), 0644)
+	at := func(hour int) string {
+		return time.Date(2018, 3, 21, hour, 0, 0, 0, time.Local).Format(time.RFC3339Nano)
+	}
+	ioutil.WriteFile("test/json.log", []byte(
+		`{"time":"`+at(9)+`","level":"error","msg":"early"}`+"\n"+
+			`{"time":"`+at(10)+`","level":"info","msg":"started"}`+"\n"+
+			`{"time":"`+at(11)+`","level":"crit","msg":"disk full"}`+"\n"), 0644)
+	stdout.Reset()
+	code = run([]string{"-config", "test/json.json", "-from", "2018-03-21 10:00", "-level", "error"}, &stdout, &stderr)
+	want = `{"time":"` + at(11) + `","level":"crit","msg":"disk full"}` + "\n"
+	if code != 0 || stdout.String() != want {
+		t.Fatalf("%d: %q %s", code, stdout.String(), stderr.String())
+	}
+
+	// logfmt 形式のログは、time, level, status キーから判定する
+	ioutil.WriteFile("test/logfmt.json", []byte(`{
+		"errorlog": {"path": "test/logfmt-error.log", "encoding": "logfmt"},
+		"accesslog": {"path": "test/logfmt-access.log", "format": "%at %st %up", "encoding": "logfmt"}
+	}`), 0644)
+	ioutil.WriteFile("test/logfmt-error.log", []byte(
+		"time="+at(9)+" level=error msg=early\n"+
+			"time="+at(10)+" level=info msg=started\n"+
+			"time="+at(11)+" level=crit caller=main.go:11 msg=\"disk full level=debug\"\n"), 0644)
+	stdout.Reset()
+	code = run([]string{"-config", "test/logfmt.json", "-from", "2018-03-21 10:00", "-level", "error"}, &stdout, &stderr)
+	want = "time=" + at(11) + " level=crit caller=main.go:11 msg=\"disk full level=debug\"\n"
+	if code != 0 || stdout.String() != want {
+		t.Fatalf("%d: %q %s", code, stdout.String(), stderr.String())
+	}
+	ioutil.WriteFile("test/logfmt-access.log", []byte(
+		"time="+at(10)+" status=200 path=/index.html\n"+
+			"time="+at(11)+" status=503 path=/api/users\n"), 0644)
+	stdout.Reset()
+	code = run([]string{"-config", "test/logfmt.json", "-section", "accesslog", "-from", "2018-03-21 10:00", "-status", "5xx"}, &stdout, &stderr)
+	want = "time=" + at(11) + " status=503 path=/api/users\n"
+	if code != 0 || stdout.String() != want {
+		t.Fatalf("%d: %q %s", code, stdout.String(), stderr.String())
+	}
+
+	// 暗号化したアーカイブは、-key に指定した鍵で復号する
+	os.MkdirAll("test/enc", 0755)
+	ioutil.WriteFile("test/enc.json", []byte(`{
+		"logger": {"path": "test/enc/app.log", "lotate": "test/enc/app-%Y%m%d.log"}
+	}`), 0644)
+	key := bytes.Repeat([]byte{1}, 32)
+	ioutil.WriteFile("test/enc/key", []byte(hex.EncodeToString(key)+"\n"), 0600)
+	var enc bytes.Buffer
+	ew, err := logger.NewEncryptWriter(&enc, &logger.StaticKeys{Current: "k1", Keys: map[string][]byte{"k1": key}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ew.Write([]byte("secret\n"))
+	ew.Close()
+	ioutil.WriteFile("test/enc/app-20180321.log.enc", enc.Bytes(), 0644)
+	stdout.Reset()
+	code = run([]string{"-config", "test/enc.json", "-key", "k1=test/enc/key"}, &stdout, &stderr)
+	if code != 0 || stdout.String() != "secret\n" {
+		t.Fatalf("%d: %q %s", code, stdout.String(), stderr.String())
+	}
+	// 鍵を指定しない場合は、エラーとする
+	stdout.Reset()
+	stderr.Reset()
+	code = run([]string{"-config", "test/enc.json"}, &stdout, &stderr)
+	if code != 1 || !strings.Contains(stderr.String(), "encrypted archive requires -key") {
+		t.Fatalf("%d: %q %s", code, stdout.String(), stderr.String())
+	}
+	if code = run([]string{"-config", "test/enc.json", "-key", "k1"}, &stdout, &stderr); code != 2 {
+		t.Fatalf("invalid -key must be error: %d", code)
+	}
+}
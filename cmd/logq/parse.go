@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ochipin/logger/errorlog"
+)
+
+// Parser 構造体は、Format で出力された1行から、日時、ログレベル、ステータスを取得する構造体
+type Parser struct {
+	re     *regexp.Regexp              // Format から生成した正規表現
+	layout string                      // 日時の書式
+	decode func(string) (Record, bool) // JSON, logfmt 形式の1行を解析する。nil の場合は re を使用する
+}
+
+// Record 構造体は、Parser で取得した1行の情報を取り扱う構造体
+type Record struct {
+	Time   time.Time // ログの日時。取得できない場合はゼロ値
+	Level  int       // ログレベル。取得できない場合は -1
+	Status int       // 接続ステータス。取得できない場合は 0
+}
+
+// errorlog の Format 指定子に対応する正規表現
+var errorlogDirectives = map[byte]string{
+	'D': `(?P<date>\d{4}-\d{2}-\d{2})`,
+	'T': `(?P<time>\d{2}:\d{2}:\d{2})`,
+	'L': `(?P<level>[a-z]+)`,
+	'M': `(?P<message>.*)`,
+}
+
+// accesslog の Format 指定子に対応する正規表現
+var accesslogDirectives = map[string]string{
+	"at": `(?P<datetime>\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2})`,
+	"st": `(?P<status>\d{3})`,
+}
+
+// NewErrorlogParser : errorlog の Format から Parser を生成する。%D, %T, %L 以外の指定子は任意の文字列とみなす
+func NewErrorlogParser(format string) (*Parser, error) {
+	var b strings.Builder
+	seen := map[byte]bool{}
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			b.WriteString(regexp.QuoteMeta(format[i : i+1]))
+			continue
+		}
+		i++
+		// 同じ指定子が複数ある場合、2つ目以降は任意の文字列とみなす
+		if re, ok := errorlogDirectives[format[i]]; ok && !seen[format[i]] {
+			seen[format[i]] = true
+			b.WriteString(re)
+		} else {
+			b.WriteString(`.*?`)
+		}
+	}
+	return newParser(b.String(), "2006-01-02 15:04:05")
+}
+
+// NewAccesslogParser : accesslog の Format から Parser を生成する。%at, %st 以外の指定子は任意の文字列とみなす
+func NewAccesslogParser(format string) (*Parser, error) {
+	var b strings.Builder
+	seen := map[string]bool{}
+	for n, s := range strings.Split(format, "%%") {
+		if n != 0 {
+			b.WriteString("%")
+		}
+		for i := 0; i < len(s); i++ {
+			switch {
+			case s[i] != '%' || i+1 == len(s):
+				b.WriteString(regexp.QuoteMeta(s[i : i+1]))
+			case s[i+1] == '{':
+				// %{NAME}i, %{NAME}e, %{NAME}c
+				end := strings.Index(s[i:], "}")
+				if end == -1 || i+end+1 == len(s) {
+					b.WriteString(regexp.QuoteMeta(s[i:]))
+					i = len(s)
+					continue
+				}
+				b.WriteString(`.*?`)
+				i += end + 1
+			case i+2 < len(s):
+				name := s[i+1 : i+3]
+				if re, ok := accesslogDirectives[name]; ok && !seen[name] {
+					seen[name] = true
+					b.WriteString(re)
+				} else {
+					b.WriteString(`.*?`)
+				}
+				i += 2
+			default:
+				b.WriteString(regexp.QuoteMeta(s[i:]))
+				i = len(s)
+			}
+		}
+	}
+	return newParser(b.String(), "2006-01-02 15:04:05")
+}
+
+// NewJSONParser : errorlog の JSON 形式のログから Parser を生成する。time, level キーの値を使用する
+func NewJSONParser() *Parser {
+	return &Parser{decode: parseJSON}
+}
+
+// JSON 形式の1行を解析する。JSON オブジェクトでない行は、false を返却する
+func parseJSON(line string) (Record, bool) {
+	var v struct {
+		Time  string `json:"time"`
+		Level string `json:"level"`
+	}
+	if json.Unmarshal([]byte(line), &v) != nil {
+		return Record{}, false
+	}
+	return newRecord(v.Time, v.Level, ""), true
+}
+
+// NewLogfmtParser : errorlog, accesslog の logfmt 形式のログから Parser を生成する。time, level, status キーの値を使用する
+func NewLogfmtParser() *Parser {
+	return &Parser{decode: parseLogfmt}
+}
+
+// logfmt 形式の1行を解析する。key=value で始まらない行は、false を返却する
+func parseLogfmt(line string) (Record, bool) {
+	values := map[string]string{}
+	for line = strings.TrimLeft(line, " "); line != ""; line = strings.TrimLeft(line, " ") {
+		eq := strings.IndexAny(line, "= ")
+		if eq <= 0 || line[eq] != '=' {
+			return Record{}, false
+		}
+		key, value := line[:eq], ""
+		line = line[eq+1:]
+		if strings.HasPrefix(line, `"`) {
+			quoted, err := strconv.QuotedPrefix(line)
+			if err != nil {
+				return Record{}, false
+			}
+			value, _ = strconv.Unquote(quoted)
+			line = line[len(quoted):]
+		} else {
+			end := strings.IndexByte(line, ' ')
+			if end == -1 {
+				end = len(line)
+			}
+			value, line = line[:end], line[end:]
+		}
+		// 同じキーが複数ある場合は、最初の値を使用する
+		if _, ok := values[key]; !ok {
+			values[key] = value
+		}
+	}
+	if len(values) == 0 {
+		return Record{}, false
+	}
+	return newRecord(values["time"], values["level"], values["status"]), true
+}
+
+// RFC3339 形式の日時、ログレベル名、接続ステータスから Record を生成する。取得できない値は初期値とする
+func newRecord(datetime, level, status string) Record {
+	rec := Record{Level: -1}
+	rec.Time, _ = time.Parse(time.RFC3339Nano, datetime)
+	if n, err := errorlog.ParseLevel(level); err == nil {
+		rec.Level = n
+	}
+	rec.Status, _ = strconv.Atoi(status)
+	return rec
+}
+
+func newParser(expr, layout string) (*Parser, error) {
+	re, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return nil, fmt.Errorf("logq: format can not be parsed: %v", err)
+	}
+	return &Parser{re: re, layout: layout}, nil
+}
+
+// Parse : 1行を解析する。Format に一致しない行は、false を返却する
+func (p *Parser) Parse(line string) (Record, bool) {
+	if p.decode != nil {
+		return p.decode(line)
+	}
+	m := p.re.FindStringSubmatch(line)
+	if m == nil {
+		return Record{}, false
+	}
+	rec := Record{Level: -1}
+	var date, clock string
+	for i, name := range p.re.SubexpNames() {
+		switch name {
+		case "date":
+			date = m[i]
+		case "time":
+			clock = m[i]
+		case "datetime":
+			date, clock = m[i][:10], m[i][11:]
+		case "level":
+			if level, err := errorlog.ParseLevel(m[i]); err == nil {
+				rec.Level = level
+			}
+		case "status":
+			rec.Status, _ = strconv.Atoi(m[i])
+		}
+	}
+	if date != "" && clock != "" {
+		rec.Time, _ = time.ParseInLocation(p.layout, date+" "+clock, time.Local)
+	} else if date != "" {
+		rec.Time, _ = time.ParseInLocation(p.layout[:10], date, time.Local)
+	}
+	return rec, true
+}
@@ -0,0 +1,534 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ochipin/logger"
+	"github.com/ochipin/logger/accesslog"
+	"github.com/ochipin/logger/errorlog"
+)
+
+var matchTiming = regexp.MustCompile(`^\d\d:\d\d$`)
+
+// Config 構造体は、設定ファイルから読み込んだログ設定を取り扱う構造体
+type Config struct {
+	Logger    *Log       `json:"logger"`    // logger.Log の設定
+	Errorlog  *ErrorLog  `json:"errorlog"`  // errorlog.Log の設定
+	Accesslog *AccessLog `json:"accesslog"` // accesslog.Log の設定
+}
+
+// Log 構造体は、logger.Log の設定値を取り扱う構造体
+type Log struct {
+	Path          string   `json:"path"`           // ログ保存パス
+	Lotate        string   `json:"lotate"`         // ログローテーションファイル名
+	Timing        string   `json:"timing"`         // ログローテーションタイミング
+	MaxLines      int      `json:"max_lines"`      // ログローテーションするログファイルの行数
+	Interval      Duration `json:"interval"`       // ログローテーションする間隔
+	Newline       bool     `json:"newline"`        // ログ保存時に、改行を含めるか否か
+	Tabspace      bool     `json:"tabspace"`       // ログ保存時に、タブを空白に置き換えるか
+	Trim          bool     `json:"trim"`           // ログ保存時に、Trimする
+	Prefix        string   `json:"prefix"`         // ログの先頭へ付与する文字列
+	TimeLayout    string   `json:"time_layout"`    // prefix の %t に使用する日時の書式
+	TimePrecision int      `json:"time_precision"` // prefix の %t に付与する、秒の小数点以下の桁数
+	Name          string   `json:"name"`           // prefix の %n に使用するロガー名
+	Perm          Perm     `json:"perm"`           // ログファイル作成時のパーミッション
+	DirPerm       Perm     `json:"dir_perm"`       // ディレクトリ作成時のパーミッション
+	Owner         string   `json:"owner"`          // ログファイル、ディレクトリの所有者
+	Group         string   `json:"group"`          // ログファイル、ディレクトリのグループ
+	Overwrite     bool     `json:"overwrite"`      // ログローテーション時に、既にあるファイルに対して上書きする
+	DiskSoft      Size     `json:"disk_soft"`      // アーカイブの圧縮と削除を開始する空き容量
+	DiskHard      Size     `json:"disk_hard"`      // ログファイルへの書き込みを停止する空き容量
+	DiskCheck     Duration `json:"disk_check"`     // 空き容量を確認する間隔
+	DiskKeep      int      `json:"disk_keep"`      // 空き容量が不足した場合も、削除せずに残すアーカイブの数
+	FallbackPath  string   `json:"fallback_path"`  // ログファイルへ書き込めない場合に、代わりに書き込むログファイル
+	RetryCount    int      `json:"retry_count"`    // 書き込みに失敗した場合に、再試行する回数
+	RetryWait     Duration `json:"retry_wait"`     // 再試行までの待ち時間
+	SpoolSize     int      `json:"spool_size"`     // 書き込めなかったログを、メモリ上に保持する最大行数
+	Sync          string   `json:"sync"`           // ログファイルを fsync するタイミング
+	ManifestPath  string   `json:"manifest_path"`  // ログローテーションしたアーカイブの一覧を書き込むファイル
+	Dedup         bool     `json:"dedup"`          // 直前と同じログを出力せずに集計する
+	DedupNumbers  bool     `json:"dedup_numbers"`  // 重複判定時に、数値の違いを無視する
+	DedupFlush    Duration `json:"dedup_flush"`    // 同じログが続いた場合に、集計行を出力する間隔
+	RateLimit     float64  `json:"rate_limit"`     // 1秒あたりに出力するログの行数の上限
+	RateBurst     int      `json:"rate_burst"`     // rate_limit を超えて、一度に出力できるログの行数
+	RateReport    Duration `json:"rate_report"`    // 出力を制限したログの行数を出力する間隔
+}
+
+// ErrorLog 構造体は、errorlog.Log の設定値を取り扱う構造体
+type ErrorLog struct {
+	Log
+	Format    string  `json:"format"`     // ログフォーマット
+	Encoding  string  `json:"encoding"`   // ログの形式。text, json, logfmt のいずれか
+	Level     Level   `json:"level"`      // ログレベル
+	VModule   string  `json:"vmodule"`    // パッケージ、ファイルごとのログレベル ex) db*=7,http=4
+	Depth     int     `json:"depth"`      // 実行された関数、行番号等を取得する際に使用する階層
+	Binname   string  `json:"binname"`    // ロードモジュール名
+	SiteLimit float64 `json:"site_limit"` // 呼び出し元ごとに、1秒あたりに出力するログの行数の上限
+	SiteBurst int     `json:"site_burst"` // site_limit を超えて、呼び出し元ごとに一度に出力できるログの行数
+}
+
+// AccessLog 構造体は、accesslog.Log の設定値を取り扱う構造体
+type AccessLog struct {
+	Log
+	Format   string `json:"format"`    // ログフォーマット
+	Encoding string `json:"encoding"`  // ログの形式。text, logfmt のいずれか
+	Modename string `json:"modename"`  // 起動モード名
+	ServerIP string `json:"server_ip"` // サーバのIPアドレス
+}
+
+// Level : ログレベル。"warn" 等のログレベル名、または 0-7 の数値で指定する
+type Level string
+
+// UnmarshalJSON : 文字列、数値のどちらで指定されたログレベルも受け付ける
+func (v *Level) UnmarshalJSON(b []byte) error {
+	s, err := scalarJSON(b)
+	if err != nil {
+		return err
+	}
+	*v = Level(s)
+	return nil
+}
+
+// Int : ログレベル値を返却する。未指定の場合は 0 を返却する
+func (v Level) Int() (int, error) {
+	if v == "" {
+		return 0, nil
+	}
+	return errorlog.ParseLevel(string(v))
+}
+
+// Perm : パーミッション。"0640", 640 のどちらで指定しても8進数として扱う
+type Perm string
+
+// UnmarshalJSON : 文字列、数値のどちらで指定されたパーミッションも受け付ける
+func (v *Perm) UnmarshalJSON(b []byte) error {
+	s, err := scalarJSON(b)
+	if err != nil {
+		return err
+	}
+	*v = Perm(s)
+	return nil
+}
+
+// Int : パーミッション値を返却する。未指定の場合は 0 を返却する
+func (v Perm) Int() (int, error) {
+	if v == "" {
+		return 0, nil
+	}
+	perm, err := strconv.ParseUint(string(v), 8, 32)
+	if err != nil || perm > 0777 {
+		return 0, fmt.Errorf("\"%s\" is not octal permission", string(v))
+	}
+	return int(perm), nil
+}
+
+// Size : バイト数。"512MB", "1GiB" 等の単位付き文字列、または数値で指定する
+type Size string
+
+// UnmarshalJSON : 文字列、数値のどちらで指定されたバイト数も受け付ける
+func (v *Size) UnmarshalJSON(b []byte) error {
+	s, err := scalarJSON(b)
+	if err != nil {
+		return err
+	}
+	*v = Size(s)
+	return nil
+}
+
+// 単位とバイト数の対応
+var sizeUnits = []struct {
+	suffix string
+	size   uint64
+}{
+	{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30}, {"TIB", 1 << 40},
+	{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000}, {"TB", 1000 * 1000 * 1000 * 1000},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40}, {"B", 1},
+}
+
+// Int : バイト数を返却する。未指定の場合は 0 を返却する
+func (v Size) Int() (uint64, error) {
+	s := strings.ToUpper(strings.TrimSpace(string(v)))
+	if s == "" {
+		return 0, nil
+	}
+	var unit uint64 = 1
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s, unit = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("\"%s\" is not size", string(v))
+	}
+	return n * unit, nil
+}
+
+// Duration : 時間間隔。"10s", "5m" 等の time.ParseDuration 形式で指定する
+type Duration string
+
+// Value : 時間間隔を返却する。未指定の場合は 0 を返却する
+func (v Duration) Value() (time.Duration, error) {
+	if v == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(string(v))
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("\"%s\" is not duration", string(v))
+	}
+	return d, nil
+}
+
+// JSON の文字列、または数値を文字列として取得する
+func scalarJSON(b []byte) (string, error) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return "", err
+	}
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case nil:
+		return "", nil
+	}
+	return "", fmt.Errorf("%s is not string or number", string(b))
+}
+
+// FieldError 構造体は、設定値のエラーを、エラーとなったフィールド名と共に取り扱う構造体
+type FieldError struct {
+	Field string // エラーとなったフィールド名 ex) errorlog.level
+	Err   error  // エラー内容
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("config: %s: %v", e.Field, e.Err)
+}
+
+// Errors : 設定値の検証で検出した全てのエラー
+type Errors []*FieldError
+
+func (e Errors) Error() string {
+	var messages []string
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Load : 設定ファイルを読み込む。ファイル形式は拡張子(.json/.yaml/.yml/.toml)で判断する
+func Load(path string) (*Config, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(buf, strings.TrimPrefix(filepath.Ext(path), "."))
+}
+
+// Parse : 指定された形式(json/yaml/yml/toml)の設定を読み込み、検証する
+func Parse(buf []byte, format string) (*Config, error) {
+	var values map[string]interface{}
+	var err error
+	switch strings.ToLower(format) {
+	case "json":
+		values, err = parseJSON(buf)
+	case "yaml", "yml":
+		values, err = parseYAML(buf)
+	case "toml":
+		values, err = parseTOML(buf)
+	default:
+		return nil, fmt.Errorf("config: \"%s\" is unsupported format", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeMap(values)
+}
+
+// LoadEnv : 環境変数から設定を読み込む。環境変数名は <prefix>_<セクション>_<キー> の形式で指定する
+// ex) LOG_ERRORLOG_LEVEL=warn, LOG_ACCESSLOG_SERVER_IP=192.168.1.3
+func LoadEnv(prefix string) (*Config, error) {
+	values := map[string]interface{}{}
+	for _, section := range fields(configType) {
+		var sub = map[string]interface{}{}
+		for _, key := range fields(section.typ) {
+			name := strings.ToUpper(prefix + "_" + section.name + "_" + key.name)
+			if v, ok := os.LookupEnv(name); ok {
+				sub[key.name] = v
+			}
+		}
+		if len(sub) != 0 {
+			values[section.name] = sub
+		}
+	}
+	return decodeMap(values)
+}
+
+// 文字列で指定された値をフィールドの型に合わせて変換した後、設定を読み込む
+func decodeMap(values map[string]interface{}) (*Config, error) {
+	if err := coerce(values, configType, ""); err != nil {
+		return nil, err
+	}
+	buf, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	return decode(buf)
+}
+
+// JSON 形式の設定を読み込み、検証する
+func decode(buf []byte) (*Config, error) {
+	var c Config
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return nil, decodeError(err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// JSON の読み込みエラーを、フィールド名を含むエラーへ変換する
+func decodeError(err error) error {
+	switch e := err.(type) {
+	case *json.UnmarshalTypeError:
+		return &FieldError{e.Field, fmt.Errorf("cannot use %s as %s", e.Value, e.Type)}
+	case *json.SyntaxError:
+		return fmt.Errorf("config: offset %d: %v", e.Offset, e)
+	}
+	// ex) json: unknown field "levle"
+	if s := err.Error(); strings.HasPrefix(s, "json: unknown field ") {
+		name, _ := strconv.Unquote(strings.TrimPrefix(s, "json: unknown field "))
+		return &FieldError{name, fmt.Errorf("unknown field")}
+	}
+	return fmt.Errorf("config: %v", err)
+}
+
+// Validate : 全ての設定値を検証し、不正な設定値をフィールド名と共に返却する
+func (c *Config) Validate() error {
+	var errs Errors
+	if c.Logger == nil && c.Errorlog == nil && c.Accesslog == nil {
+		errs = append(errs, &FieldError{"config", fmt.Errorf("logger, errorlog or accesslog is required")})
+	}
+	if c.Logger != nil {
+		errs = append(errs, c.Logger.validate("logger")...)
+	}
+	if c.Errorlog != nil {
+		errs = append(errs, c.Errorlog.validate("errorlog")...)
+	}
+	if c.Accesslog != nil {
+		errs = append(errs, c.Accesslog.validate("accesslog")...)
+	}
+	if len(errs) != 0 {
+		return errs
+	}
+	return nil
+}
+
+func (c *Log) validate(section string) Errors {
+	var errs Errors
+	// ローテーション時刻は 00:00-23:59 の範囲で指定する
+	if c.Timing != "" {
+		if !matchTiming.MatchString(c.Timing) {
+			errs = append(errs, &FieldError{section + ".timing", fmt.Errorf("\"%s\" is not HH:MM format", c.Timing)})
+		} else if hour, _ := strconv.Atoi(c.Timing[:2]); hour > 23 {
+			errs = append(errs, &FieldError{section + ".timing", fmt.Errorf("hour must be 00-23")})
+		} else if minute, _ := strconv.Atoi(c.Timing[3:]); minute > 59 {
+			errs = append(errs, &FieldError{section + ".timing", fmt.Errorf("minute must be 00-59")})
+		}
+	}
+	// ローテーション後のファイル名を検証する
+	if c.Lotate != "" {
+		if _, filename := filepath.Split(c.Lotate); filename == "" {
+			errs = append(errs, &FieldError{section + ".lotate", fmt.Errorf("\"%s\" has no filename", c.Lotate)})
+		}
+	}
+	if _, err := c.Perm.Int(); err != nil {
+		errs = append(errs, &FieldError{section + ".perm", err})
+	}
+	if _, err := c.DirPerm.Int(); err != nil {
+		errs = append(errs, &FieldError{section + ".dir_perm", err})
+	}
+	// 所有者、グループは、反映する前に存在を確認する
+	if _, _, err := logger.LookupOwner(c.Owner, ""); err != nil {
+		errs = append(errs, &FieldError{section + ".owner", err})
+	}
+	if _, _, err := logger.LookupOwner("", c.Group); err != nil {
+		errs = append(errs, &FieldError{section + ".group", err})
+	}
+	if _, err := c.DiskSoft.Int(); err != nil {
+		errs = append(errs, &FieldError{section + ".disk_soft", err})
+	}
+	if _, err := c.DiskHard.Int(); err != nil {
+		errs = append(errs, &FieldError{section + ".disk_hard", err})
+	}
+	if _, err := c.DiskCheck.Value(); err != nil {
+		errs = append(errs, &FieldError{section + ".disk_check", err})
+	}
+	if c.DiskKeep < 0 {
+		errs = append(errs, &FieldError{section + ".disk_keep", fmt.Errorf("disk_keep must be 0 or more")})
+	}
+	if c.RetryCount < 0 {
+		errs = append(errs, &FieldError{section + ".retry_count", fmt.Errorf("retry_count must be 0 or more")})
+	}
+	if _, err := c.RetryWait.Value(); err != nil {
+		errs = append(errs, &FieldError{section + ".retry_wait", err})
+	}
+	if _, err := c.DedupFlush.Value(); err != nil {
+		errs = append(errs, &FieldError{section + ".dedup_flush", err})
+	}
+	if c.RateLimit < 0 || c.RateBurst < 0 {
+		errs = append(errs, &FieldError{section + ".rate_limit", fmt.Errorf("rate_limit and rate_burst must be 0 or more")})
+	}
+	if _, err := c.RateReport.Value(); err != nil {
+		errs = append(errs, &FieldError{section + ".rate_report", err})
+	}
+	if c.TimePrecision < 0 || c.TimePrecision > 9 {
+		errs = append(errs, &FieldError{section + ".time_precision", fmt.Errorf("time_precision must be 0-9")})
+	}
+	if c.MaxLines < 0 {
+		errs = append(errs, &FieldError{section + ".max_lines", fmt.Errorf("max_lines must be 0 or more")})
+	}
+	if _, err := c.Interval.Value(); err != nil {
+		errs = append(errs, &FieldError{section + ".interval", err})
+	}
+	if c.SpoolSize < 0 {
+		errs = append(errs, &FieldError{section + ".spool_size", fmt.Errorf("spool_size must be 0 or more")})
+	}
+	if _, err := logger.ParseSyncPolicy(c.Sync); err != nil {
+		errs = append(errs, &FieldError{section + ".sync", fmt.Errorf("\"%s\" is not none, always, lines or duration", c.Sync)})
+	}
+	return errs
+}
+
+func (c *ErrorLog) validate(section string) Errors {
+	errs := c.Log.validate(section)
+	// JSON 形式の場合は Format を使用せず、logfmt 形式の場合は既定のフォーマットを使用する
+	if c.Format == "" && c.Encoding != errorlog.EncodingJSON && c.Encoding != errorlog.EncodingLogfmt {
+		errs = append(errs, &FieldError{section + ".format", fmt.Errorf("format is required")})
+	}
+	switch c.Encoding {
+	case "", errorlog.EncodingText, errorlog.EncodingJSON, errorlog.EncodingLogfmt:
+	default:
+		errs = append(errs, &FieldError{section + ".encoding", fmt.Errorf("\"%s\" is not text, json or logfmt", c.Encoding)})
+	}
+	if _, err := c.Level.Int(); err != nil {
+		errs = append(errs, &FieldError{section + ".level", err})
+	}
+	if _, err := errorlog.ParseVModule(c.VModule); err != nil {
+		errs = append(errs, &FieldError{section + ".vmodule", err})
+	}
+	if c.Depth < 0 {
+		errs = append(errs, &FieldError{section + ".depth", fmt.Errorf("depth must be 0 or more")})
+	}
+	if c.SiteLimit < 0 || c.SiteBurst < 0 {
+		errs = append(errs, &FieldError{section + ".site_limit", fmt.Errorf("site_limit and site_burst must be 0 or more")})
+	}
+	return errs
+}
+
+func (c *AccessLog) validate(section string) Errors {
+	errs := c.Log.validate(section)
+	if c.Format == "" {
+		errs = append(errs, &FieldError{section + ".format", fmt.Errorf("format is required")})
+	}
+	if c.Encoding != "" && c.Encoding != accesslog.EncodingText && c.Encoding != accesslog.EncodingLogfmt {
+		errs = append(errs, &FieldError{section + ".encoding", fmt.Errorf("\"%s\" is not text or logfmt", c.Encoding)})
+	}
+	return errs
+}
+
+// Build : 設定値から logger.Log を生成する
+func (c *Log) Build() (*logger.Log, error) {
+	if errs := c.validate("logger"); len(errs) != 0 {
+		return nil, errs
+	}
+	l := &logger.Log{}
+	c.apply(l)
+	return l, nil
+}
+
+// logger.Log へ設定値を反映する
+func (c *Log) apply(l *logger.Log) {
+	perm, _ := c.Perm.Int()
+	l.Path = c.Path
+	l.Lotate = c.Lotate
+	l.Timing = c.Timing
+	l.MaxLines = c.MaxLines
+	l.Interval, _ = c.Interval.Value()
+	l.Newline = c.Newline
+	l.Tabspace = c.Tabspace
+	l.Trim = c.Trim
+	l.Prefix = c.Prefix
+	l.TimeLayout = c.TimeLayout
+	l.TimePrecision = c.TimePrecision
+	l.Name = c.Name
+	l.Perm = perm
+	l.DirPerm, _ = c.DirPerm.Int()
+	l.Owner = c.Owner
+	l.Group = c.Group
+	l.Overwrite = c.Overwrite
+	l.DiskSoft, _ = c.DiskSoft.Int()
+	l.DiskHard, _ = c.DiskHard.Int()
+	l.DiskCheck, _ = c.DiskCheck.Value()
+	l.DiskKeep = c.DiskKeep
+	l.FallbackPath = c.FallbackPath
+	l.RetryCount = c.RetryCount
+	l.RetryWait, _ = c.RetryWait.Value()
+	l.SpoolSize = c.SpoolSize
+	l.SyncPolicy, _ = logger.ParseSyncPolicy(c.Sync)
+	l.ManifestPath = c.ManifestPath
+	l.Dedup = c.Dedup
+	l.DedupNumbers = c.DedupNumbers
+	l.DedupFlush, _ = c.DedupFlush.Value()
+	l.RateLimit = c.RateLimit
+	l.RateBurst = c.RateBurst
+	l.RateReport, _ = c.RateReport.Value()
+}
+
+// Build : 設定値から errorlog.Log を生成する
+func (c *ErrorLog) Build() (*errorlog.Log, error) {
+	if errs := c.validate("errorlog"); len(errs) != 0 {
+		return nil, errs
+	}
+	l := &errorlog.Log{}
+	c.Log.apply(&l.Log)
+	l.Format = c.Format
+	l.Encoding = c.Encoding
+	l.Level, _ = c.Level.Int()
+	l.VModule = c.VModule
+	l.Depth = c.Depth
+	l.Binname = c.Binname
+	l.SiteLimit = c.SiteLimit
+	l.SiteBurst = c.SiteBurst
+	return l, nil
+}
+
+// Build : 設定値から accesslog.Log を生成する
+func (c *AccessLog) Build() (*accesslog.Log, error) {
+	if errs := c.validate("accesslog"); len(errs) != 0 {
+		return nil, errs
+	}
+	l := &accesslog.Log{}
+	c.Log.apply(&l.Log)
+	l.Format = c.Format
+	l.Encoding = c.Encoding
+	l.Modename = c.Modename
+	l.ServerIP = c.ServerIP
+	return l, nil
+}
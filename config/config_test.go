@@ -0,0 +1,243 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ochipin/logger"
+	"github.com/ochipin/logger/errorlog"
+)
+
+// JSON/YAML/TOML の各形式から同じ設定を読み込めるか
+func TestParse(t *testing.T) {
+	sources := map[string]string{
+		"json": `{
+			"logger": {"path": "test/app.log", "lotate": "test/%Y%m/app-%Y%m%d.log", "timing": "00:00", "perm": "0640"},
+			"errorlog": {"path": "test/error.log", "format": "%D %T %L: %M", "level": "warn", "depth": 3},
+			"accesslog": {"format": "%ra %st", "server_ip": "192.168.1.3", "newline": true}
+		}`,
+		"yaml": `
+# アプリケーションログ
+logger:
+  path: test/app.log
+  lotate: "test/%Y%m/app-%Y%m%d.log"
+  timing: "00:00"
+  perm: 0640
+errorlog:
+  path: test/error.log
+  format: "%D %T %L: %M" # フォーマット
+  level: warn
+  depth: 3
+accesslog:
+  format: '%ra %st'
+  server_ip: 192.168.1.3
+  newline: true
+`,
+		"toml": `
+[logger]
+path = "test/app.log"
+lotate = "test/%Y%m/app-%Y%m%d.log"
+timing = "00:00"
+perm = 640
+
+[errorlog]
+path = "test/error.log"
+format = "%D %T %L: %M"
+level = "warn"
+depth = 3
+
+[accesslog]
+format = '%ra %st'
+server_ip = "192.168.1.3"
+newline = true
+`,
+	}
+	for format, src := range sources {
+		c, err := Parse([]byte(src), format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		l, err := c.Logger.Build()
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if l.Path != "test/app.log" || l.Timing != "00:00" || l.Perm != 0640 {
+			t.Fatalf("%s: logger = %+v", format, c.Logger)
+		}
+		e, err := c.Errorlog.Build()
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if e.Level != 4 || e.Depth != 3 || e.Format != "%D %T %L: %M" {
+			t.Fatalf("%s: errorlog = %+v", format, c.Errorlog)
+		}
+		a, err := c.Accesslog.Build()
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if a.ServerIP != "192.168.1.3" || !a.Newline || a.Format != "%ra %st" {
+			t.Fatalf("%s: accesslog = %+v", format, c.Accesslog)
+		}
+	}
+}
+
+// 不正な設定値はフィールド名と共にエラーとなるか
+func TestParseError(t *testing.T) {
+	tests := map[string]string{
+		`{"errorlog": {"format": "%M", "level": "verbose"}}`:   "errorlog.level",
+		`{"errorlog": {"format": "%M", "levle": "warn"}}`:      "errorlog.levle",
+		`{"logger": {"path": "a.log", "timing": "24:00"}}`:     "logger.timing",
+		`{"logger": {"path": "a.log", "lotate": "log/%Y%m/"}}`: "logger.lotate",
+		`{"logger": {"path": "a.log", "perm": "0899"}}`:        "logger.perm",
+		`{"logger": {"newline": "yes please"}}`:                "logger.newline",
+		`{"accesslog": {"path": "a.log"}}`:                     "accesslog.format",
+		`{"errorlog": {"format": "%M", "depth": -1}}`:          "errorlog.depth",
+		`{"errorlog": {"format": "%M", "vmodule": "db"}}`:      "errorlog.vmodule",
+		`{"errorlog": {"encoding": "xml"}}`:                    "errorlog.encoding",
+		`{"accesslog": {"format": "%ra", "encoding": "json"}}`: "accesslog.encoding",
+		`{"logger": {"disk_soft": "lots"}}`:                    "logger.disk_soft",
+		`{"logger": {"disk_check": "-1s"}}`:                    "logger.disk_check",
+		`{"logger": {"disk_keep": -1}}`:                        "logger.disk_keep",
+		`{"logger": {"owner": "no-such-user-x"}}`:              "logger.owner",
+		`{"errorlog": {"format": "%M", "group": "no-such-x"}}`: "errorlog.group",
+		`{}`: "config",
+	}
+	for src, field := range tests {
+		_, err := Parse([]byte(src), "json")
+		if err == nil {
+			t.Fatalf("%s: error expected", src)
+		}
+		if !strings.Contains(err.Error(), "config: "+field+":") {
+			t.Fatalf("%s: %v", src, err)
+		}
+	}
+	if _, err := Parse([]byte("logger:\n  - path"), "yaml"); err == nil {
+		t.Fatal("yaml sequence must be error")
+	}
+	if _, err := Parse([]byte("[logger\npath = 1"), "toml"); err == nil {
+		t.Fatal("invalid toml table must be error")
+	}
+	if _, err := Parse([]byte(""), "ini"); err == nil {
+		t.Fatal("unsupported format must be error")
+	}
+}
+
+// 単位付きのバイト数を読み込めるか
+func TestSize(t *testing.T) {
+	tests := map[Size]uint64{"": 0, "1024": 1024, "2KB": 2000, "512MiB": 512 << 20, "1g": 1 << 30}
+	for size, expect := range tests {
+		if n, err := size.Int(); err != nil || n != expect {
+			t.Fatalf("%s: %d, %v", size, n, err)
+		}
+	}
+}
+
+// 環境変数から設定を読み込めるか
+func TestLoadEnv(t *testing.T) {
+	os.Setenv("TESTLOG_ERRORLOG_FORMAT", "%L: %M")
+	os.Setenv("TESTLOG_ERRORLOG_LEVEL", "debug")
+	os.Setenv("TESTLOG_ERRORLOG_TRIM", "true")
+	defer os.Unsetenv("TESTLOG_ERRORLOG_FORMAT")
+	defer os.Unsetenv("TESTLOG_ERRORLOG_LEVEL")
+	defer os.Unsetenv("TESTLOG_ERRORLOG_TRIM")
+
+	c, err := LoadEnv("testlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Logger != nil || c.Accesslog != nil {
+		t.Fatal("unspecified section must be nil")
+	}
+	e, err := c.Errorlog.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Level != 7 || !e.Trim || e.Format != "%L: %M" {
+		t.Fatalf("errorlog = %+v", c.Errorlog)
+	}
+
+	os.Setenv("TESTLOG_ERRORLOG_TRIM", "maybe")
+	if _, err := LoadEnv("testlog"); err == nil || !strings.Contains(err.Error(), "errorlog.trim") {
+		t.Fatalf("%v", err)
+	}
+}
+
+// 設定ファイルの変更が、動作中のログ管理構造体へ反映されるか
+func TestWatcher(t *testing.T) {
+	os.MkdirAll("test", 0755)
+	path := "test/watch.yaml"
+	if err := ioutil.WriteFile(path, []byte("errorlog:\n  format: \"%L: %M\"\n  level: warn\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log, err := c.Errorlog.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := log.MakeLog(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	w := &Watcher{Path: path, Interval: 10 * time.Millisecond, Errorlog: log}
+	// 不正な設定値は反映されない
+	bad := &Config{Errorlog: &ErrorLog{Format: "%M", Level: "verbose"}}
+	if err := w.Reload(bad); err == nil || log.Level != 4 {
+		t.Fatalf("invalid config must not be applied: %v", err)
+	}
+
+	errs := make(chan error, 10)
+	w.OnError = func(err error) { errs <- err }
+	if err := w.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+	if err := ioutil.WriteFile(path, []byte("errorlog:\n  format: \"%D %L: %M\"\n  level: debug\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+		if log.GetLevel() == 7 {
+			break
+		}
+	}
+	// Format は Level より先に反映されるため、GetLevel で反映を確認した後に参照する
+	if log.GetLevel() != 7 || log.Format != "%D %L: %M" {
+		t.Fatalf("config is not reloaded: level=%d format=%s", log.GetLevel(), log.Format)
+	}
+
+	// 読み込みに失敗した場合は OnError が呼び出される
+	if err := ioutil.WriteFile(path, []byte("errorlog:\n  level: [debug]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-errs:
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnError is not called")
+	}
+}
+
+// 存在しない所有者、グループは反映する前に検出し、他のセクションも反映しない
+func TestReloadOwner(t *testing.T) {
+	base := &logger.Log{Path: "test/reload/app.log"}
+	if _, err := base.MakeLog(nil); err != nil {
+		t.Fatal(err)
+	}
+	elog := &errorlog.Log{Format: "%M", Level: 4}
+	if _, err := elog.MakeLog(nil); err != nil {
+		t.Fatal(err)
+	}
+	w := &Watcher{Logger: base, Errorlog: elog}
+	bad := &Config{
+		Logger:   &Log{Path: "test/reload/other.log"},
+		Errorlog: &ErrorLog{Format: "%M", Log: Log{Owner: "no-such-user-x"}},
+	}
+	if err := w.Reload(bad); err == nil || base.Path != "test/reload/app.log" {
+		t.Fatalf("config is applied partially: %v %s", err, base.Path)
+	}
+}
@@ -0,0 +1,292 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var configType = reflect.TypeOf(Config{})
+
+// 設定項目の名前と型
+type field struct {
+	name string
+	typ  reflect.Type
+}
+
+// 構造体の json タグから設定項目の一覧を取得する。埋め込まれた構造体の設定項目も含める
+func fields(t reflect.Type) []field {
+	var result []field
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous {
+			result = append(result, fields(f.Type)...)
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		typ := f.Type
+		if typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		result = append(result, field{name, typ})
+	}
+	return result
+}
+
+// 設定値をフィールドの型に合わせて変換する。存在しないフィールドはエラーとする
+func coerce(values map[string]interface{}, t reflect.Type, path string) error {
+	var known = map[string]reflect.Type{}
+	for _, f := range fields(t) {
+		known[f.name] = f.typ
+	}
+	for key, value := range values {
+		name := key
+		if path != "" {
+			name = path + "." + key
+		}
+		typ, ok := known[key]
+		if !ok {
+			return &FieldError{name, fmt.Errorf("unknown field")}
+		}
+		v, err := convert(value, typ, name)
+		if err != nil {
+			return err
+		}
+		values[key] = v
+	}
+	return nil
+}
+
+// 1つの設定値をフィールドの型に合わせて変換する
+func convert(value interface{}, typ reflect.Type, name string) (interface{}, error) {
+	switch typ.Kind() {
+	case reflect.Struct:
+		sub, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, &FieldError{name, fmt.Errorf("must be a table")}
+		}
+		return sub, coerce(sub, typ, name)
+	case reflect.Bool:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			if b, err := strconv.ParseBool(v); err == nil {
+				return b, nil
+			}
+		}
+		return nil, &FieldError{name, fmt.Errorf("%v is not boolean", value)}
+	case reflect.Int:
+		switch v := value.(type) {
+		case json.Number:
+			if n, err := v.Int64(); err == nil {
+				return n, nil
+			}
+		case string:
+			if n, err := strconv.Atoi(v); err == nil {
+				return n, nil
+			}
+		}
+		return nil, &FieldError{name, fmt.Errorf("%v is not integer", value)}
+	case reflect.Float64:
+		switch v := value.(type) {
+		case json.Number:
+			if n, err := v.Float64(); err == nil {
+				return n, nil
+			}
+		case string:
+			if n, err := strconv.ParseFloat(v, 64); err == nil {
+				return n, nil
+			}
+		}
+		return nil, &FieldError{name, fmt.Errorf("%v is not number", value)}
+	case reflect.String:
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case json.Number:
+			return v.String(), nil
+		}
+		return nil, &FieldError{name, fmt.Errorf("%v is not string", value)}
+	}
+	return value, nil
+}
+
+// JSON 形式の設定を読み込む
+func parseJSON(buf []byte) (map[string]interface{}, error) {
+	var values map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	if err := dec.Decode(&values); err != nil {
+		return nil, decodeError(err)
+	}
+	return values, nil
+}
+
+// YAML 形式の設定を読み込む。インデントによる入れ子のマッピングと、スカラー値のみ取り扱う
+func parseYAML(buf []byte) (map[string]interface{}, error) {
+	type level struct {
+		indent int
+		values map[string]interface{}
+	}
+	root := map[string]interface{}{}
+	stack := []level{{-1, root}}
+	// 値が空のキーは、次の行のインデントが深ければマッピングとして扱う
+	var pending string
+	var pendingIndent = -1
+
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	for num := 1; scanner.Scan(); num++ {
+		line := stripComment(scanner.Text())
+		if strings.TrimSpace(line) == "" || strings.TrimSpace(line) == "---" {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		if strings.HasPrefix(strings.TrimLeft(line, " "), "\t") {
+			return nil, fmt.Errorf("config: line %d: tab indentation is not allowed", num)
+		}
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "- ") || line == "-" {
+			return nil, fmt.Errorf("config: line %d: sequence is not supported", num)
+		}
+		// 直前のキーの子要素であれば、新たなマッピングを作成する
+		if pending != "" {
+			parent := stack[len(stack)-1].values
+			if indent > pendingIndent {
+				child := map[string]interface{}{}
+				parent[pending] = child
+				stack = append(stack, level{indent, child})
+			} else {
+				parent[pending] = ""
+			}
+			pending = ""
+		}
+		for len(stack) > 1 && indent < stack[len(stack)-1].indent {
+			stack = stack[:len(stack)-1]
+		}
+		if indent != stack[len(stack)-1].indent && len(stack) > 1 {
+			return nil, fmt.Errorf("config: line %d: invalid indentation", num)
+		}
+
+		idx := strings.Index(line, ":")
+		if idx <= 0 || (idx+1 < len(line) && line[idx+1] != ' ') {
+			return nil, fmt.Errorf("config: line %d: \"key: value\" is expected", num)
+		}
+		key := strings.TrimSpace(line[:idx])
+		value := strings.TrimSpace(line[idx+1:])
+		if value == "" {
+			pending, pendingIndent = key, indent
+			continue
+		}
+		v, err := unquote(value)
+		if err != nil {
+			return nil, fmt.Errorf("config: line %d: %v", num, err)
+		}
+		stack[len(stack)-1].values[key] = v
+	}
+	if pending != "" {
+		stack[len(stack)-1].values[pending] = ""
+	}
+	return root, scanner.Err()
+}
+
+// TOML 形式の設定を読み込む。テーブルとスカラー値のみ取り扱う
+func parseTOML(buf []byte) (map[string]interface{}, error) {
+	root := map[string]interface{}{}
+	current := root
+
+	// ドット区切りのキーに対応するテーブルを取得する
+	table := func(base map[string]interface{}, keys []string) (map[string]interface{}, error) {
+		for _, key := range keys {
+			key = strings.TrimSpace(key)
+			if key == "" {
+				return nil, fmt.Errorf("empty key")
+			}
+			next, ok := base[key]
+			if !ok {
+				next = map[string]interface{}{}
+				base[key] = next
+			}
+			if base, ok = next.(map[string]interface{}); !ok {
+				return nil, fmt.Errorf("\"%s\" is not a table", key)
+			}
+		}
+		return base, nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	for num := 1; scanner.Scan(); num++ {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+		// [section] の場合、以降の値を格納するテーブルを切り替える
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("config: line %d: invalid table header", num)
+			}
+			t, err := table(root, strings.Split(line[1:len(line)-1], "."))
+			if err != nil {
+				return nil, fmt.Errorf("config: line %d: %v", num, err)
+			}
+			current = t
+			continue
+		}
+		idx := strings.Index(line, "=")
+		if idx <= 0 {
+			return nil, fmt.Errorf("config: line %d: \"key = value\" is expected", num)
+		}
+		keys := strings.Split(strings.TrimSpace(line[:idx]), ".")
+		t, err := table(current, keys[:len(keys)-1])
+		if err != nil {
+			return nil, fmt.Errorf("config: line %d: %v", num, err)
+		}
+		v, err := unquote(strings.TrimSpace(line[idx+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("config: line %d: %v", num, err)
+		}
+		t[strings.TrimSpace(keys[len(keys)-1])] = v
+	}
+	return root, scanner.Err()
+}
+
+// 引用符で囲まれていない # 以降をコメントとして取り除く
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// 引用符で囲まれた値は引用符を取り除き、それ以外の値はそのまま文字列として返却する
+func unquote(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		return strconv.Unquote(value)
+	case strings.HasPrefix(value, "'"):
+		if len(value) < 2 || !strings.HasSuffix(value, "'") {
+			return "", fmt.Errorf("%s: unterminated string", value)
+		}
+		return strings.Replace(value[1:len(value)-1], "''", "'", -1), nil
+	}
+	return value, nil
+}
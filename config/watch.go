@@ -0,0 +1,153 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ochipin/logger"
+	"github.com/ochipin/logger/accesslog"
+	"github.com/ochipin/logger/errorlog"
+)
+
+// Watcher 構造体は、設定ファイルの変更を監視し、動作中のログ管理構造体へ設定を反映する構造体
+type Watcher struct {
+	Path      string         // 監視する設定ファイル
+	Interval  time.Duration  // 設定ファイルの監視間隔。未指定の場合は5秒
+	Logger    *logger.Log    // logger セクションを反映するログ管理構造体
+	Errorlog  *errorlog.Log  // errorlog セクションを反映するログ管理構造体
+	Accesslog *accesslog.Log // accesslog セクションを反映するログ管理構造体
+	OnError   func(error)    // 設定ファイルの読み込み、反映に失敗した場合に呼び出される関数
+	mu        sync.Mutex     // 同時反映を制御するMutex
+	stop      chan struct{}  // 監視を停止するチャネル
+	modtime   time.Time      // 最後に読み込んだ設定ファイルの更新日時
+	size      int64          // 最後に読み込んだ設定ファイルのサイズ
+}
+
+// Reload : 設定を動作中のログ管理構造体へ反映する。
+// 全てのセクションの設定値を検証してから反映するため、不正な設定値が含まれる場合は何も反映しない
+func (w *Watcher) Reload(c *Config) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	// 1. 反映する設定値を生成する
+	var log *logger.Log
+	var elog *errorlog.Log
+	var alog *accesslog.Log
+	var err error
+	if w.Logger != nil && c.Logger != nil {
+		if log, err = c.Logger.Build(); err != nil {
+			return err
+		}
+	}
+	if w.Errorlog != nil && c.Errorlog != nil {
+		if elog, err = c.Errorlog.Build(); err != nil {
+			return err
+		}
+	}
+	if w.Accesslog != nil && c.Accesslog != nil {
+		if alog, err = c.Accesslog.Build(); err != nil {
+			return err
+		}
+	}
+
+	// 2. 動作中のログ管理構造体へ反映する
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if log != nil {
+		if err := w.Logger.Apply(log); err != nil {
+			return &FieldError{"logger", err}
+		}
+	}
+	if elog != nil {
+		if err := w.Errorlog.Apply(elog); err != nil {
+			return &FieldError{"errorlog", err}
+		}
+	}
+	if alog != nil {
+		if err := w.Accesslog.Apply(alog); err != nil {
+			return &FieldError{"accesslog", err}
+		}
+	}
+	return nil
+}
+
+// Start : 設定ファイルの監視を開始する。設定ファイルが更新された場合、読み込み直して反映する
+func (w *Watcher) Start() error {
+	info, err := os.Stat(w.Path)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stop != nil {
+		return fmt.Errorf("config: watcher is already started")
+	}
+	w.modtime, w.size = info.ModTime(), info.Size()
+	w.stop = make(chan struct{})
+
+	interval := w.Interval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	go w.watch(interval, w.stop)
+	return nil
+}
+
+// Stop : 設定ファイルの監視を停止する
+func (w *Watcher) Stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stop != nil {
+		close(w.stop)
+		w.stop = nil
+	}
+}
+
+// 設定ファイルの更新日時、サイズを監視する
+func (w *Watcher) watch(interval time.Duration, stop chan struct{}) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick.C:
+			if err := w.check(); err != nil {
+				w.error(err)
+			}
+		}
+	}
+}
+
+// 設定ファイルが更新されていれば、読み込み直して反映する
+func (w *Watcher) check() error {
+	info, err := os.Stat(w.Path)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	changed := !info.ModTime().Equal(w.modtime) || info.Size() != w.size
+	w.modtime, w.size = info.ModTime(), info.Size()
+	w.mu.Unlock()
+	if !changed {
+		return nil
+	}
+
+	c, err := Load(w.Path)
+	if err != nil {
+		return err
+	}
+	return w.Reload(c)
+}
+
+// 設定ファイルの読み込み、反映エラーを通知する
+func (w *Watcher) error(err error) {
+	if w.OnError != nil {
+		w.OnError(err)
+		return
+	}
+	fmt.Fprintln(os.Stderr, err)
+}
@@ -0,0 +1,14 @@
+//go:build linux
+// +build linux
+
+package logger
+
+import (
+	"os"
+	"syscall"
+)
+
+// ファイルのデータを fdatasync する。更新日時等のメタデータは、データの読み込みに必要なものを除き書き込まない
+func datasync(fp *os.File) error {
+	return syscall.Fdatasync(int(fp.Fd()))
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package logger
+
+import "os"
+
+// fdatasync に対応していない環境では、fsync する
+func datasync(fp *os.File) error {
+	return fp.Sync()
+}
@@ -0,0 +1,85 @@
+package logger
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// 重複判定時に、同一とみなす数値
+var matchNumber = regexp.MustCompile(`[0-9]+`)
+
+// 重複したログを出力しないまま経過した場合に、集計行を出力するまでの時間のデフォルト値
+const dedupWait = 10 * time.Second
+
+// 重複判定に使用するキーを生成する。DedupNumbers が有効な場合は、数値を "0" に置き換える
+func (l *Log) dedupKey(s string) string {
+	if l.DedupNumbers {
+		return matchNumber.ReplaceAllString(s, "0")
+	}
+	return s
+}
+
+// 直前と同じログを出力せずに集計し、異なるログを出力する時点で集計行を出力する。
+// key が空文字列の場合は、s で重複を判定する。l.mu をロックした状態で呼び出すこと
+func (l *Log) dedup(key, s string) error {
+	if !l.Dedup {
+		// Apply で無効化された場合に備え、集計中のログがあれば出力する
+		err := l.repeated()
+		if e := l.emit(s); e != nil {
+			err = e
+		}
+		return err
+	}
+
+	if key == "" {
+		key = s
+	}
+	key = l.dedupKey(key)
+	if l.dedupRun && key == l.dedupLast {
+		l.dedupCount++
+		l.dedupLine = s
+		l.stats.repeated++
+		// 一定時間同じログが続いた場合にも集計行を出力するよう、タイマーを設定する
+		if l.dedupTimer == nil {
+			wait := l.DedupFlush
+			if wait <= 0 {
+				wait = dedupWait
+			}
+			l.dedupTimer = time.AfterFunc(wait, func() { l.report(l.dedupExpire) })
+		}
+		return nil
+	}
+
+	err := l.repeated()
+	l.dedupLast, l.dedupRun = key, true
+	if e := l.emit(s); e != nil {
+		err = e
+	}
+	return err
+}
+
+// 集計中のログがあれば、"message repeated N times" を出力する。集計したログが1行の場合は、そのログを出力する。
+// l.mu をロックした状態で呼び出すこと
+func (l *Log) repeated() error {
+	if l.dedupTimer != nil {
+		l.dedupTimer.Stop()
+		l.dedupTimer = nil
+	}
+	if l.dedupCount == 0 {
+		return nil
+	}
+	count, line := l.dedupCount, l.dedupLine
+	l.dedupCount, l.dedupLine = 0, ""
+	if count == 1 {
+		return l.emit(line)
+	}
+	return l.emit(fmt.Sprintf("message repeated %d times", count))
+}
+
+// タイマーにより集計行を出力し、次のログは重複していても出力する。l.mu をロックした状態で呼び出すこと
+func (l *Log) dedupExpire() error {
+	err := l.repeated()
+	l.dedupRun = false
+	return err
+}
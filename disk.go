@@ -0,0 +1,260 @@
+package logger
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// 空き容量を取得する関数。テスト時に差し替えられるよう変数としている
+var diskFree = freeSpace
+
+// 空き容量不足による書き込みエラーか否かを判定する
+func isDiskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
+
+// 空き容量を監視する goroutine を起動する。l.mu をロックした状態で呼び出すこと
+func (l *Log) guard() {
+	if !l.keeping || l.guarding || l.Path == "" || (l.DiskSoft == 0 && l.DiskHard == 0) {
+		return
+	}
+	l.guarding = true
+
+	go func() {
+		for {
+			l.mu.Lock()
+			interval := l.DiskCheck
+			// 空き容量の監視が無効化された場合は、書き込みを再開し goroutine を終了する
+			if l.Path == "" || (l.DiskSoft == 0 && l.DiskHard == 0) {
+				l.guarding = false
+				l.diskFull = false
+				l.mu.Unlock()
+				return
+			}
+			l.mu.Unlock()
+
+			l.checkDisk()
+			if interval <= 0 {
+				interval = 10 * time.Second
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// 空き容量を確認し、閾値を下回っていればアーカイブの圧縮と削除、書き込みの停止を行う
+func (l *Log) checkDisk() {
+	l.mu.Lock()
+	dir, _ := filepath.Split(l.Path)
+	soft, hard := l.DiskSoft, l.DiskHard
+	l.mu.Unlock()
+	if dir == "" {
+		dir = "."
+	}
+
+	free, err := diskFree(dir)
+	if err != nil {
+		l.alert("logger: " + err.Error())
+		return
+	}
+	// 1. 空き容量が DiskSoft を下回った場合、アーカイブを圧縮し、古いアーカイブから削除する
+	if free < soft {
+		free = l.retention(dir, soft, free)
+	}
+
+	// 2. 空き容量が DiskHard を下回った場合、ファイルへの書き込みを停止する。回復した場合は再開する
+	l.mu.Lock()
+	full := l.diskFull
+	l.diskFull = free < hard
+	l.mu.Unlock()
+	if !full && free < hard {
+		l.alert("logger: free disk space is low, stop writing log file")
+	} else if full && free >= hard {
+		l.alert("logger: free disk space is available, resume writing log file")
+	}
+}
+
+// 空き容量が soft を上回るまで、アーカイブの圧縮と、古いアーカイブの削除を行い、処理後の空き容量を返却する。
+// 新しいアーカイブは、DiskKeep の数だけ削除せずに残す
+func (l *Log) retention(dir string, soft, free uint64) uint64 {
+	l.mu.Lock()
+	keep := l.DiskKeep
+	l.mu.Unlock()
+	if keep <= 0 {
+		keep = 1
+	}
+	archives := l.archives()
+	// 1. 未圧縮のアーカイブを圧縮する
+	for i, path := range archives {
+		// 暗号化したアーカイブは、圧縮しても小さくならないため圧縮しない
+		if strings.HasSuffix(path, ".gz") || strings.HasSuffix(path, encryptExt) {
+			continue
+		}
+		gz, err := l.compress(path)
+		if err != nil {
+			l.alert("logger: " + err.Error())
+			continue
+		}
+		archives[i] = gz
+	}
+	if free, err := diskFree(dir); err != nil || free >= soft {
+		return free
+	}
+	// 2. 空き容量が回復するまで、古いアーカイブから削除する。新しいアーカイブは DiskKeep の数だけ残す
+	if len(archives) > keep {
+		archives = archives[:len(archives)-keep]
+	} else {
+		archives = nil
+	}
+	for _, path := range archives {
+		if err := os.Remove(path); err != nil {
+			l.alert("logger: " + err.Error())
+			continue
+		}
+		// ハッシュチェインのサイドカーファイルも削除し、マニフェストから取り除く
+		os.Remove(strings.TrimSuffix(strings.TrimSuffix(path, ".gz"), encryptExt) + chainExt)
+		l.mu.Lock()
+		// 削除したアーカイブは、アップロードしない
+		l.loadQueue()
+		l.dequeue(path)
+		serr := l.saveQueue()
+		merr := l.removeSegment(path)
+		l.mu.Unlock()
+		for _, err := range []error{serr, merr} {
+			if err != nil {
+				l.alert("logger: " + err.Error())
+			}
+		}
+		l.alert("logger: free disk space is low, removed " + path)
+		if free, err := diskFree(dir); err != nil || free >= soft {
+			return free
+		}
+	}
+	free, _ = diskFree(dir)
+	return free
+}
+
+// ログローテーション済みのアーカイブを、古い順に返却する。ManifestPath を指定した場合はログローテーションした順、
+// 指定していない場合は更新日時の順とする。次回のローテーションで書き込まれるアーカイブは含めない
+func (l *Log) archives() []string {
+	l.mu.Lock()
+	path, lotate := l.Path, l.Lotate
+	current := l.getLotateName(time.Now())
+	l.mu.Unlock()
+	if lotate == "" {
+		return nil
+	}
+
+	// ex) log/%Y%m/app-%Y%m%d.log ---> log/*/app-*.log
+	rep := strings.NewReplacer("%Y", "*", "%m", "*", "%d", "*", "%H", "*", "%M", "*", "%N", "*", "%w", "*")
+	pattern := rep.Replace(lotate)
+	var matches []string
+	for _, p := range []string{pattern, pattern + ".gz", pattern + encryptExt} {
+		m, _ := filepath.Glob(p)
+		matches = append(matches, m...)
+	}
+
+	type archive struct {
+		path    string
+		modtime time.Time
+	}
+	var list []archive
+	for _, m := range matches {
+		if m == filepath.Clean(path) || m == filepath.Clean(current) {
+			continue
+		}
+		if info, err := os.Stat(m); err == nil && info.Mode().IsRegular() {
+			list = append(list, archive{m, info.ModTime()})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].modtime.Before(list[j].modtime) })
+
+	var result []string
+	for _, a := range list {
+		result = append(result, a.path)
+	}
+	return l.manifestOrder(result)
+}
+
+// Archives : 次回のローテーションで書き込まれるアーカイブを含めて、ログローテーション済みのアーカイブを古い順に返却する。
+// ManifestPath を指定した場合はログローテーションした順、指定していない場合は更新日時の順とする。
+// gzip 圧縮、暗号化されたアーカイブも含まれる
+func (l *Log) Archives() []string {
+	archives := l.archives()
+	l.mu.Lock()
+	path, current := l.Path, ""
+	if l.Lotate != "" {
+		current = l.getLotateName(time.Now())
+	}
+	l.mu.Unlock()
+	if current == "" {
+		return archives
+	}
+	for _, p := range []string{current, current + ".gz", current + encryptExt} {
+		if p != filepath.Clean(path) {
+			if info, err := os.Stat(p); err == nil && info.Mode().IsRegular() {
+				archives = append(archives, p)
+			}
+		}
+	}
+	sort.SliceStable(archives, func(i, j int) bool {
+		a, _ := os.Stat(archives[i])
+		b, _ := os.Stat(archives[j])
+		return a != nil && b != nil && a.ModTime().Before(b.ModTime())
+	})
+	return l.manifestOrder(archives)
+}
+
+// アーカイブを gzip 形式で圧縮し、圧縮後のファイル名を返却する。
+// 圧縮済みのファイルが既に存在する場合は、gzip メンバとして追記する
+func (l *Log) compress(path string) (string, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	gzpath := path + ".gz"
+	fp, err := os.OpenFile(gzpath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, info.Mode().Perm())
+	if err != nil {
+		return "", err
+	}
+	zw := gzip.NewWriter(fp)
+	_, err = io.Copy(zw, src)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	// 圧縮前のファイルを削除する前に fsync する
+	if err == nil {
+		err = fp.Sync()
+	}
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	l.mu.Lock()
+	err = l.chown(gzpath)
+	if err == nil {
+		err = l.renameSegment(path, gzpath)
+	}
+	l.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	// 更新日時を引き継ぎ、圧縮前のファイルを削除する
+	os.Chtimes(gzpath, info.ModTime(), info.ModTime())
+	return gzpath, os.Remove(path)
+}
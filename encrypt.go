@@ -0,0 +1,296 @@
+package logger
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// 暗号化したアーカイブの拡張子、ストリームの書式
+const (
+	encryptExt   = ".enc"     // 暗号化したアーカイブの拡張子
+	encryptMagic = "LOGENC01" // ストリームの先頭に書き込むマジックナンバー
+	encryptChunk = 64 * 1024  // 1チャンクあたりの平文の最大バイト数
+)
+
+// KeyProvider : アーカイブの暗号化に使用する鍵を提供するインタフェース。
+// 鍵は AES-256 の 32 バイトで、暗号化したアーカイブには鍵IDが記録されるため、鍵を切り替えた後も古い鍵で復号できる
+type KeyProvider interface {
+	CurrentKey() (string, []byte, error) // 暗号化に使用する鍵IDと鍵を返却する
+	Key(id string) ([]byte, error)       // 復号に使用する、鍵IDに対応する鍵を返却する
+}
+
+// StaticKeys 構造体は、鍵IDと鍵の組み合わせを保持する KeyProvider
+type StaticKeys struct {
+	Current string            // 暗号化に使用する鍵ID
+	Keys    map[string][]byte // 鍵IDと鍵の組み合わせ
+}
+
+// CurrentKey : 暗号化に使用する鍵IDと鍵を返却する
+func (k *StaticKeys) CurrentKey() (string, []byte, error) {
+	key, err := k.Key(k.Current)
+	return k.Current, key, err
+}
+
+// Key : 鍵IDに対応する鍵を返却する
+func (k *StaticKeys) Key(id string) ([]byte, error) {
+	key, ok := k.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("logger: encryption key \"%s\" is not found", id)
+	}
+	return key, nil
+}
+
+// ErrTruncated : 暗号化したストリームが途中で途切れている場合に返却されるエラー
+var ErrTruncated = errors.New("logger: encrypted stream is truncated")
+
+// ストリームのヘッダとチャンク番号から、チャンクの nonce と追加認証データを生成する。
+// 最終チャンクを追加認証データに含めることで、末尾のチャンクの削除を検出する
+func chunkNonce(prefix []byte, counter uint32, header []byte, last bool) ([]byte, []byte) {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[8:], counter)
+	ad := append(append([]byte{}, header...), 0)
+	if last {
+		ad[len(ad)-1] = 1
+	}
+	return nonce, ad
+}
+
+// AES-256-GCM を生成する
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("logger: encryption key must be 32 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptWriter 構造体は、書き込まれた内容をチャンク単位で暗号化する構造体
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	header  []byte // ストリームのヘッダ。マジックナンバー、鍵ID、nonce の接頭辞
+	prefix  []byte // nonce の接頭辞
+	counter uint32 // チャンク番号
+	buf     []byte // 暗号化前の平文
+	closed  bool
+}
+
+// NewEncryptWriter : KeyProvider の現在の鍵で、書き込まれた内容を AES-256-GCM で暗号化する io.WriteCloser を生成する。
+// Close するまで、ストリームは完結しない。暗号化したストリームは連結しても、NewDecryptReader で復号できる
+func NewEncryptWriter(w io.Writer, keys KeyProvider) (io.WriteCloser, error) {
+	id, key, err := keys.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	if len(id) > 255 {
+		return nil, fmt.Errorf("logger: encryption key id is too long")
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, 8)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	// ヘッダ: マジックナンバー(8) | 鍵IDの長さ(1) | 鍵ID | nonce の接頭辞(8)
+	header := append([]byte(encryptMagic), byte(len(id)))
+	header = append(append(header, id...), prefix...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead, header: header, prefix: prefix}, nil
+}
+
+func (e *encryptWriter) Write(b []byte) (int, error) {
+	if e.closed {
+		return 0, fmt.Errorf("logger: write to closed encrypt writer")
+	}
+	n := len(b)
+	for len(b) != 0 {
+		size := encryptChunk - len(e.buf)
+		if size > len(b) {
+			size = len(b)
+		}
+		e.buf = append(e.buf, b[:size]...)
+		b = b[size:]
+		// 最終チャンクを判別するため、次の書き込みがあるまでチャンク1つ分を保持する
+		if len(e.buf) == encryptChunk && len(b) != 0 {
+			if err := e.flush(false); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// 保持している平文を、1チャンクとして暗号化して書き込む
+func (e *encryptWriter) flush(last bool) error {
+	nonce, ad := chunkNonce(e.prefix, e.counter, e.header, last)
+	sealed := e.aead.Seal(nil, nonce, e.buf, ad)
+	// チャンク: 暗号文の長さ(4) | 暗号文
+	size := make([]byte, 4)
+	binary.BigEndian.PutUint32(size, uint32(len(sealed)))
+	if _, err := e.w.Write(append(size, sealed...)); err != nil {
+		return err
+	}
+	e.counter++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+// Close : 最終チャンクを書き込み、ストリームを完結させる。下位の io.Writer は Close しない
+func (e *encryptWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.flush(true)
+}
+
+// decryptReader 構造体は、暗号化されたストリームを復号する構造体
+type decryptReader struct {
+	r       *bufio.Reader
+	keys    KeyProvider
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	counter uint32
+	buf     []byte // 復号済みで未読の平文
+	done    bool   // 最終チャンクまで読み込んだか否か
+	err     error
+}
+
+// NewDecryptReader : NewEncryptWriter で暗号化したストリームを復号する io.Reader を生成する。
+// 連結されたストリームは順に復号し、ストリームが途中で途切れている場合は ErrTruncated を返却する
+func NewDecryptReader(r io.Reader, keys KeyProvider) io.Reader {
+	return &decryptReader{r: bufio.NewReader(r), keys: keys, done: true}
+}
+
+func (d *decryptReader) Read(b []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		d.err = d.next()
+	}
+	n := copy(b, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+// 次のチャンクを読み込んで復号する。ストリームが完結していれば、次のストリームのヘッダを読み込む
+func (d *decryptReader) next() error {
+	if d.done {
+		if _, err := d.r.Peek(1); err == io.EOF {
+			return io.EOF
+		}
+		if err := d.readHeader(); err != nil {
+			return err
+		}
+	}
+	size := make([]byte, 4)
+	if _, err := io.ReadFull(d.r, size); err != nil {
+		return ErrTruncated
+	}
+	// 壊れたストリームにより巨大なメモリを確保しないよう、チャンクの上限を超える長さは読み込まない
+	n := binary.BigEndian.Uint32(size)
+	if n > uint32(encryptChunk+d.aead.Overhead()) {
+		return fmt.Errorf("logger: encrypted chunk %d is corrupted", d.counter)
+	}
+	sealed := make([]byte, n)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		return ErrTruncated
+	}
+	// 最終チャンクか否かは、追加認証データで判別する
+	for _, last := range []bool{false, true} {
+		nonce, ad := chunkNonce(d.prefix, d.counter, d.header, last)
+		if plain, err := d.aead.Open(nil, nonce, sealed, ad); err == nil {
+			d.buf, d.done = plain, last
+			d.counter++
+			return nil
+		}
+	}
+	return fmt.Errorf("logger: encrypted chunk %d is corrupted or the key is wrong", d.counter)
+}
+
+// ストリームのヘッダを読み込み、鍵IDに対応する鍵を取得する
+func (d *decryptReader) readHeader() error {
+	head := make([]byte, len(encryptMagic)+1)
+	if _, err := io.ReadFull(d.r, head); err != nil {
+		return ErrTruncated
+	}
+	if !bytes.Equal(head[:len(encryptMagic)], []byte(encryptMagic)) {
+		return fmt.Errorf("logger: encrypted stream header is invalid")
+	}
+	rest := make([]byte, int(head[len(encryptMagic)])+8)
+	if _, err := io.ReadFull(d.r, rest); err != nil {
+		return ErrTruncated
+	}
+	key, err := d.keys.Key(string(rest[:len(rest)-8]))
+	if err != nil {
+		return err
+	}
+	if d.aead, err = newGCM(key); err != nil {
+		return err
+	}
+	d.header = append(head, rest...)
+	d.prefix = rest[len(rest)-8:]
+	d.counter, d.done = 0, false
+	return nil
+}
+
+// アーカイブを暗号化して <アーカイブ>.enc へ追記し、暗号化前のアーカイブを削除する。
+// overwrite が true の場合は、既存の暗号化したアーカイブを作り直す
+func encryptFile(path string, keys KeyProvider, perm os.FileMode, overwrite bool) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	encpath := path + encryptExt
+	if overwrite {
+		os.Remove(encpath)
+	}
+	fp, err := os.OpenFile(encpath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, perm)
+	if err != nil {
+		return err
+	}
+	var size int64
+	if info, err := fp.Stat(); err == nil {
+		size = info.Size()
+	}
+	w, err := NewEncryptWriter(fp, keys)
+	if err == nil {
+		_, err = io.Copy(w, src)
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+	}
+	// 暗号化に失敗した場合は、途中まで書き込んだストリームを取り除く。平文のアーカイブを削除する前に fsync する
+	if err != nil {
+		fp.Truncate(size)
+	} else {
+		err = fp.Sync()
+	}
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -6,8 +6,10 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ochipin/logger"
@@ -15,15 +17,77 @@ import (
 
 var matchSource = regexp.MustCompile(`%[fml]`)
 
+// ログレベル値
+const (
+	LevelEmerg  = iota // 0: emerg
+	LevelAlert         // 1: alert
+	LevelCrit          // 2: crit
+	LevelError         // 3: error
+	LevelWarn          // 4: warn
+	LevelNotice        // 5: notice
+	LevelInfo          // 6: info
+	LevelDebug         // 7: debug
+)
+
+// ログレベル値に対応するログレベル名
+var levelNames = []string{"emerg", "alert", "crit", "error", "warn", "notice", "info", "debug"}
+
+// ログレベル名の別名
+var levelAliases = map[string]int{
+	"emergency": LevelEmerg,
+	"critical":  LevelCrit,
+	"err":       LevelError,
+	"warning":   LevelWarn,
+}
+
+// LevelName : ログレベル値からログレベル名を取得する。範囲外の値の場合は空文字列を返却する
+func LevelName(level int) string {
+	if level < 0 || level >= len(levelNames) {
+		return ""
+	}
+	return levelNames[level]
+}
+
+// ParseLevel : "warn" 等のログレベル名、または "4" 等の数値文字列からログレベル値を取得する
+func ParseLevel(s string) (int, error) {
+	name := strings.ToLower(strings.TrimSpace(s))
+	for level, v := range levelNames {
+		if v == name {
+			return level, nil
+		}
+	}
+	if level, ok := levelAliases[name]; ok {
+		return level, nil
+	}
+	if level, err := strconv.Atoi(name); err == nil && LevelName(level) != "" {
+		return level, nil
+	}
+	return 0, fmt.Errorf("\"%s\" log level is not found", s)
+}
+
 // Log 構造体は、ログ情報を取り扱う構造体
 type Log struct {
 	mu sync.Mutex
 	logger.Log
-	Format  string // ログフォーマット
-	Level   int    // ログレベル
-	Depth   int    // 実行された関数、行番号等を取得する際に使用する階層
-	Binname string // ロードモジュール名
-	pid     string // プロセスID
+	Format    string            // ログフォーマット
+	Encoding  string            // ログの形式。"json", "logfmt" を指定できる。空文字列の場合は "text" とする
+	Level     int               // ログレベル。動作中に変更する場合は SetLevel を使用する
+	VModule   string            // パッケージ、ファイルごとのログレベル。"db*=7,http=4" 形式で指定し、Level より優先する
+	Depth     int               // 実行された関数、行番号等を取得する際に使用する階層
+	Binname   string            // ロードモジュール名
+	SiteLimit float64           // 呼び出し元(ファイル名:行番号)ごとに、1秒あたりに出力するログの行数の上限。0 の場合は制限しない
+	SiteBurst int               // SiteLimit を超えて、呼び出し元ごとに一度に出力できるログの行数
+	pid       string            // プロセスID
+	levels    [8]uint64         // ログレベルごとの出力行数
+	sites     map[string]*site  // 呼び出し元ごとの出力の制限
+	siteTimer *time.Timer       // 出力を制限したログの行数を出力するタイマー
+	siteCount uint64            // SiteLimit により出力しなかったログの行数
+	vrules    []VRule           // VModule を解析したもの
+	vsites    map[uintptr]vsite // 呼び出し元ごとの、VModule を適用したログレベル
+	level     atomic.Int32      // 動作中のログレベル
+	revert    *time.Timer       // ログレベルを元に戻すタイマー
+	revertAt  time.Time         // ログレベルを元に戻す日時
+	prev      levelState        // タイマーにより戻す、変更前のログレベル
 }
 
 // Logger : ログ管理インタフェース
@@ -44,6 +108,15 @@ type Logger interface {
 	Infof(string, ...interface{})
 	Debug(...interface{})
 	Debugf(string, ...interface{})
+	Emergw(string, ...interface{})
+	Alertw(string, ...interface{})
+	Critw(string, ...interface{})
+	Errorw(string, ...interface{})
+	Warnw(string, ...interface{})
+	Noticew(string, ...interface{})
+	Infow(string, ...interface{})
+	Debugw(string, ...interface{})
+	With(...interface{}) Logger
 	Output(int, int, string, ...interface{})
 	OutputForPC(int, string, string, int, string, ...interface{})
 	GetDepth() int
@@ -55,6 +128,12 @@ func (l *Log) MakeLog(out *os.File) (Logger, error) {
 	if !(l.Level >= 0 && l.Level <= 7) {
 		return nil, fmt.Errorf("please log level set 0-7")
 	}
+	if err := checkEncoding(l.Encoding); err != nil {
+		return nil, err
+	}
+	if err := l.setVModule(l.VModule); err != nil {
+		return nil, err
+	}
 	// ソースコードの情報を取得するDepth値を検証
 	if l.Depth == 0 {
 		l.Depth = 1
@@ -65,32 +144,53 @@ func (l *Log) MakeLog(out *os.File) (Logger, error) {
 	}
 	// プロセスIDをセットする
 	l.pid = fmt.Sprint(os.Getpid())
+	l.level.Store(int32(l.Level))
 	if err := l.Initializer(out); err != nil {
 		return nil, err
 	}
 	return l, nil
 }
 
-// 指定されたログレベル名を取得する
-func (l *Log) logLevel(level int) (string, error) {
-	if l.Level >= level {
-		switch level {
-		case 0:
-			return "emerg", nil
-		case 1:
-			return "alert", nil
-		case 2:
-			return "crit", nil
-		case 3:
-			return "error", nil
-		case 4:
-			return "warn", nil
-		case 5:
-			return "notice", nil
-		case 6:
-			return "info", nil
-		case 7:
-			return "debug", nil
+// Apply : 動作中のログ管理構造体へ、src にセットされたパラメータを反映する。
+// 反映はログ出力と排他的に行われるため、出力途中のログが反映途中の設定を参照することはない
+func (l *Log) Apply(src *Log) error {
+	if !(src.Level >= 0 && src.Level <= 7) {
+		return fmt.Errorf("please log level set 0-7")
+	}
+	if err := checkEncoding(src.Encoding); err != nil {
+		return err
+	}
+	if _, err := ParseVModule(src.VModule); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.Log.Apply(&src.Log); err != nil {
+		return err
+	}
+	l.Format = src.Format
+	l.Encoding = src.Encoding
+	l.Level = src.Level
+	l.level.Store(int32(src.Level))
+	l.stopRevert()
+	if src.Depth != 0 {
+		l.Depth = src.Depth
+	}
+	if src.Binname != "" {
+		l.Binname = src.Binname
+	}
+	l.SiteLimit = src.SiteLimit
+	l.SiteBurst = src.SiteBurst
+	l.setVModule(src.VModule)
+	return nil
+}
+
+// 指定されたログレベル名を取得する。max は出力するログレベルの上限
+func (l *Log) logLevel(max, level int) (string, error) {
+	if max >= level {
+		if name := LevelName(level); name != "" {
+			return name, nil
 		}
 	}
 	return "", fmt.Errorf("\"%d\" log level is not found", level)
@@ -113,48 +213,58 @@ func (l *Log) source() (string, string, int) {
 
 // 出力するメッセージをフォーマットに沿った形式に変換する
 func (l *Log) message(level int) (string, error) {
-	// 返却する値をフォーマット文字列で初期化 ex) %D %T %f(%m:%l) %M
-	var result = l.Format
-
-	// ログレベルを取得する
-	levelname, err := l.logLevel(level)
-	if err != nil {
-		return "", err
-	}
-
-	// %f, %l, %m 等のフォーマットが存在する場合、関数名、ファイル名、行番号等を取得し埋め込む
-	if matchSource.MatchString(l.Format) {
-		filename, funcname, linenum := l.source()
-		rep := strings.NewReplacer(
-			"%f", filename,
-			"%m", funcname,
-			"%l", fmt.Sprint(linenum))
-		// ex) %D %T main.go(main:11) %M
-		result = rep.Replace(l.Format)
+	// %f, %l, %m 等のフォーマットが存在する場合、JSON 形式の場合、呼び出し元ごとに出力を制限する場合は、関数名、ファイル名、行番号等を取得する
+	filename, funcname, linenum := "", "", 0
+	max := l.GetLevel()
+	if len(l.vrules) != 0 {
+		// VModule を指定した場合は、呼び出し元のログレベルを使用する
+		s := l.site()
+		filename, funcname, linenum = s.filename, s.funcname, s.linenum
+		if s.level != -1 {
+			max = s.level
+		}
+	} else if matchSource.MatchString(l.logFormat()) || l.Encoding == EncodingJSON || l.SiteLimit > 0 {
+		filename, funcname, linenum = l.source()
 	}
-
-	// %D, %T を日時に置き換え、%Mを出力するメッセージに置き換える
-	now := time.Now()
-	rep := strings.NewReplacer(
-		"%D", now.Format("2006-01-02"),
-		"%T", now.Format("15:04:05"),
-		"%p", l.pid,
-		"%b", l.Binname,
-		"%L", levelname)
-	// 2018-03-21 21:22:02 main.go(main:11) error: a.out not found...
-	return rep.Replace(result), nil
+	return l.messageAt(max, level, filename, funcname, linenum, time.Now())
 }
 
 // 出力するメッセージフォーマットに沿った形式に変換するが、filename, funcname, line は呼び出し側で指定しなければならない
 func (l *Log) messageForPC(level int, filename, funcname string, linenum int) (string, error) {
-	// 返却する値をフォーマット文字列で初期化 ex) %D %T %f(%m:%l) %M
-	var result = l.Format
+	max := l.GetLevel()
+	if len(l.vrules) != 0 {
+		// パッケージが不明なため、VModule はファイル名のみで判定する
+		max = l.levelFor("", filename)
+	}
+	return l.messageAt(max, level, filename, funcname, linenum, time.Now())
+}
 
+// messageForPC と同様に変換するが、max を出力するログレベルの上限とし、%D, %T には now で指定された日時を使用する
+func (l *Log) messageAt(max, level int, filename, funcname string, linenum int, now time.Time) (string, error) {
 	// ログレベルを取得する
-	levelname, err := l.logLevel(level)
+	levelname, err := l.logLevel(max, level)
 	if err != nil {
 		return "", err
 	}
+	// 呼び出し元ごとの出力の上限を超えた場合は出力しない。emerg は制限しない
+	if level != LevelEmerg && !l.allowSite(level, filename, funcname, linenum) {
+		return "", errSiteLimit
+	}
+	l.levels[level]++
+	return l.format(levelname, filename, funcname, linenum, now), nil
+}
+
+// フォーマットの各指定子を置き換える。%M, %F はそのまま返却する。JSON 形式の場合は、メッセージより前の部分を返却する。
+// logfmt 形式の場合は、指定子を key=value に変換する
+func (l *Log) format(levelname, filename, funcname string, linenum int, now time.Time) string {
+	switch l.Encoding {
+	case EncodingJSON:
+		return l.formatJSON(levelname, filename, funcname, linenum, now)
+	case EncodingLogfmt:
+		return l.formatLogfmt(levelname, filename, funcname, linenum, now)
+	}
+	// 返却する値をフォーマット文字列で初期化 ex) %D %T %f(%m:%l) %M
+	var result = l.Format
 
 	// %f, %l, %m 等のフォーマットが存在する場合、関数名、ファイル名、行番号等を埋め込む
 	if matchSource.MatchString(l.Format) {
@@ -167,7 +277,6 @@ func (l *Log) messageForPC(level int, filename, funcname string, linenum int) (s
 	}
 
 	// %D, %T を日時に置き換え、%Mを出力するメッセージに置き換える
-	now := time.Now()
 	rep := strings.NewReplacer(
 		"%D", now.Format("2006-01-02"),
 		"%T", now.Format("15:04:05"),
@@ -175,7 +284,20 @@ func (l *Log) messageForPC(level int, filename, funcname string, linenum int) (s
 		"%b", l.Binname,
 		"%L", levelname)
 	// 2018-03-21 21:22:02 main.go(main:11) error: a.out not found...
-	return rep.Replace(result), nil
+	return rep.Replace(result)
+}
+
+// Stats : ログ出力の統計情報を、ログレベルごとの出力行数と共に取得する
+func (l *Log) Stats() logger.Stats {
+	stats := l.Log.Stats()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stats.Suppressed += l.siteCount
+	stats.Levels = map[string]uint64{}
+	for level, name := range levelNames {
+		stats.Levels[name] = l.levels[level]
+	}
+	return stats
 }
 
 // GetDepth : Depth 値を取得する
@@ -193,7 +315,7 @@ func (l *Log) Emerg(v ...interface{}) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	mes, _ := l.message(0)
-	l.Print(strings.Replace(mes, "%M", fmt.Sprint(v...), -1))
+	l.print(0, mes, fmt.Sprint(v...), nil)
 	os.Exit(127)
 }
 
@@ -202,7 +324,7 @@ func (l *Log) Emergf(format string, v ...interface{}) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	mes, _ := l.message(0)
-	l.Print(strings.Replace(mes, "%M", fmt.Sprintf(format, v...), -1))
+	l.print(0, mes, fmt.Sprintf(format, v...), nil)
 	os.Exit(127)
 }
 
@@ -211,7 +333,7 @@ func (l *Log) Alert(v ...interface{}) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	if mes, err := l.message(1); err == nil {
-		l.Print(strings.Replace(mes, "%M", fmt.Sprint(v...), -1))
+		l.print(1, mes, fmt.Sprint(v...), nil)
 	}
 }
 
@@ -220,7 +342,7 @@ func (l *Log) Alertf(format string, v ...interface{}) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	if mes, err := l.message(1); err == nil {
-		l.Print(strings.Replace(mes, "%M", fmt.Sprintf(format, v...), -1))
+		l.print(1, mes, fmt.Sprintf(format, v...), nil)
 	}
 }
 
@@ -229,7 +351,7 @@ func (l *Log) Crit(v ...interface{}) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	if mes, err := l.message(2); err == nil {
-		l.Print(strings.Replace(mes, "%M", fmt.Sprint(v...), -1))
+		l.print(2, mes, fmt.Sprint(v...), nil)
 	}
 }
 
@@ -238,7 +360,7 @@ func (l *Log) Critf(format string, v ...interface{}) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	if mes, err := l.message(2); err == nil {
-		l.Print(strings.Replace(mes, "%M", fmt.Sprintf(format, v...), -1))
+		l.print(2, mes, fmt.Sprintf(format, v...), nil)
 	}
 }
 
@@ -247,7 +369,7 @@ func (l *Log) Error(v ...interface{}) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	if mes, err := l.message(3); err == nil {
-		l.Print(strings.Replace(mes, "%M", fmt.Sprint(v...), -1))
+		l.print(3, mes, fmt.Sprint(v...), nil)
 	}
 }
 
@@ -256,7 +378,7 @@ func (l *Log) Errorf(format string, v ...interface{}) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	if mes, err := l.message(3); err == nil {
-		l.Print(strings.Replace(mes, "%M", fmt.Sprintf(format, v...), -1))
+		l.print(3, mes, fmt.Sprintf(format, v...), nil)
 	}
 }
 
@@ -265,7 +387,7 @@ func (l *Log) Warn(v ...interface{}) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	if mes, err := l.message(4); err == nil {
-		l.Print(strings.Replace(mes, "%M", fmt.Sprint(v...), -1))
+		l.print(4, mes, fmt.Sprint(v...), nil)
 	}
 }
 
@@ -274,7 +396,7 @@ func (l *Log) Warnf(format string, v ...interface{}) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	if mes, err := l.message(4); err == nil {
-		l.Print(strings.Replace(mes, "%M", fmt.Sprintf(format, v...), -1))
+		l.print(4, mes, fmt.Sprintf(format, v...), nil)
 	}
 }
 
@@ -283,7 +405,7 @@ func (l *Log) Notice(v ...interface{}) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	if mes, err := l.message(5); err == nil {
-		l.Print(strings.Replace(mes, "%M", fmt.Sprint(v...), -1))
+		l.print(5, mes, fmt.Sprint(v...), nil)
 	}
 }
 
@@ -292,7 +414,7 @@ func (l *Log) Noticef(format string, v ...interface{}) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	if mes, err := l.message(5); err == nil {
-		l.Print(strings.Replace(mes, "%M", fmt.Sprintf(format, v...), -1))
+		l.print(5, mes, fmt.Sprintf(format, v...), nil)
 	}
 }
 
@@ -301,7 +423,7 @@ func (l *Log) Info(v ...interface{}) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	if mes, err := l.message(6); err == nil {
-		l.Print(strings.Replace(mes, "%M", fmt.Sprint(v...), -1))
+		l.print(6, mes, fmt.Sprint(v...), nil)
 	}
 }
 
@@ -310,7 +432,7 @@ func (l *Log) Infof(format string, v ...interface{}) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	if mes, err := l.message(6); err == nil {
-		l.Print(strings.Replace(mes, "%M", fmt.Sprintf(format, v...), -1))
+		l.print(6, mes, fmt.Sprintf(format, v...), nil)
 	}
 }
 
@@ -319,7 +441,7 @@ func (l *Log) Debug(v ...interface{}) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	if mes, err := l.message(7); err == nil {
-		l.Print(strings.Replace(mes, "%M", fmt.Sprint(v...), -1))
+		l.print(7, mes, fmt.Sprint(v...), nil)
 	}
 }
 
@@ -328,7 +450,7 @@ func (l *Log) Debugf(format string, v ...interface{}) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	if mes, err := l.message(7); err == nil {
-		l.Print(strings.Replace(mes, "%M", fmt.Sprintf(format, v...), -1))
+		l.print(7, mes, fmt.Sprintf(format, v...), nil)
 	}
 }
 
@@ -339,7 +461,7 @@ func (l *Log) Output(level, depth int, format string, v ...interface{}) {
 	oldDepth := l.Depth
 	l.Depth = depth
 	if mes, err := l.message(level); err == nil {
-		l.Print(strings.Replace(mes, "%M", fmt.Sprintf(format, v...), -1))
+		l.print(level, mes, fmt.Sprintf(format, v...), nil)
 	}
 	l.Depth = oldDepth
 }
@@ -349,6 +471,6 @@ func (l *Log) OutputForPC(level int, filename, funcname string, line int, format
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	if mes, err := l.messageForPC(level, filename, funcname, line); err == nil {
-		l.Print(strings.Replace(mes, "%M", fmt.Sprintf(format, v...), -1))
+		l.print(level, mes, fmt.Sprintf(format, v...), nil)
 	}
 }
@@ -1,8 +1,18 @@
 package errorlog
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	stdlog "log"
+	"log/slog"
+	"net/http/httptest"
 	"os"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestErrorLog(t *testing.T) {
@@ -36,3 +46,410 @@ func TestErrorLog(t *testing.T) {
 		t.Fatal("logger error")
 	}
 }
+
+// log パッケージからの出力
+func TestStdLogger(t *testing.T) {
+	os.RemoveAll("test")
+	log := Log{}
+	log.Path = "test/std.log"
+	log.Format = "%f(%m:%l) %L: %M"
+	log.Level = 7
+	if _, err := log.MakeLog(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	std := log.StdLogger(LevelWarn)
+	std.SetPrefix("app: ")
+	std.SetFlags(stdlog.LstdFlags | stdlog.Lshortfile)
+	std.Println("Hello World")
+
+	log.RedirectStdLog(LevelError)
+	defer stdlog.SetOutput(os.Stderr)
+	stdlog.Printf("%s %d", "Hello World", 200)
+
+	buf, _ := ioutil.ReadFile("test/std.log")
+	lines := strings.Split(string(buf), "\n")
+	if len(lines) != 3 ||
+		!regexp.MustCompile(`^errorlog_test\.go\(TestStdLogger:\d+\) warn: Hello World$`).MatchString(lines[0]) ||
+		!regexp.MustCompile(`^errorlog_test\.go\(TestStdLogger:\d+\) error: Hello World 200$`).MatchString(lines[1]) {
+		t.Fatal(string(buf))
+	}
+}
+
+// log/slog からの出力
+func TestSlogHandler(t *testing.T) {
+	os.RemoveAll("test")
+	log := Log{}
+	log.Path = "test/slog.log"
+	log.Format = "%f(%m:%l) %L: %M"
+	log.Level = 6
+	if _, err := log.MakeLog(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	l := slog.New(log.Handler())
+	l.Debug("Hello World")
+	l.Info("Hello World", "user", "root", "path", "/a b")
+	l.Log(context.Background(), SlogLevelNotice, "Hello World")
+	l.Log(context.Background(), SlogLevelEmerg, "Hello World")
+	l.With("id", 1).WithGroup("req").With("method", "GET").Warn("Hello World", slog.Group("header", "host", "localhost"), "status", 200)
+
+	buf, _ := ioutil.ReadFile("test/slog.log")
+	lines := strings.Split(string(buf), "\n")
+	want := []string{
+		`info: Hello World user=root path="/a b"$`,
+		`notice: Hello World$`,
+		`emerg: Hello World$`,
+		`warn: Hello World id=1 req.method=GET req.header.host=localhost req.status=200$`,
+	}
+	if len(lines) != len(want)+1 {
+		t.Fatal(string(buf))
+	}
+	for i, v := range want {
+		if !regexp.MustCompile(`^errorlog_test\.go\(TestSlogHandler:\d+\) ` + v).MatchString(lines[i]) {
+			t.Fatal(string(buf))
+		}
+	}
+}
+
+// slog のログレベルの変換
+func TestSlogLevel(t *testing.T) {
+	levels := map[slog.Level]int{
+		slog.LevelDebug - 4: LevelDebug,
+		slog.LevelDebug:     LevelDebug,
+		slog.LevelInfo:      LevelInfo,
+		SlogLevelNotice:     LevelNotice,
+		slog.LevelWarn:      LevelWarn,
+		slog.LevelError:     LevelError,
+		SlogLevelCrit:       LevelCrit,
+		SlogLevelAlert:      LevelAlert,
+		SlogLevelEmerg + 4:  LevelEmerg,
+	}
+	for level, want := range levels {
+		if v := FromSlogLevel(level); v != want {
+			t.Fatalf("FromSlogLevel(%v) = %d, want %d", level, v, want)
+		}
+	}
+	for level := LevelEmerg; level <= LevelDebug; level++ {
+		if v := FromSlogLevel(ToSlogLevel(level)); v != level {
+			t.Fatalf("ToSlogLevel(%d) = %v", level, ToSlogLevel(level))
+		}
+	}
+}
+
+// 呼び出し元ごとの出力の制限
+func TestSiteLimit(t *testing.T) {
+	os.RemoveAll("test")
+	log := Log{}
+	log.Path = "test/site.log"
+	log.Format = "%f(%m) %L: %M"
+	log.Level = 7
+	log.Depth = 3
+	log.SiteLimit = 1
+	log.RateReport = 50 * time.Millisecond
+	l, err := log.MakeLog(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		l.Errorf("hot loop %d", i)
+	}
+	l.Warn("other")
+	time.Sleep(200 * time.Millisecond)
+
+	buf, _ := ioutil.ReadFile("test/site.log")
+	want := "errorlog_test.go(TestSiteLimit) error: hot loop 0\n" +
+		"errorlog_test.go(TestSiteLimit) warn: other\n" +
+		"errorlog_test.go(TestSiteLimit) error: rate limit exceeded, suppressed 4 lines\n"
+	if string(buf) != want {
+		t.Fatal(string(buf))
+	}
+	if stats := log.Stats(); stats.Suppressed != 4 || stats.Levels["error"] != 1 {
+		t.Fatal(stats)
+	}
+}
+
+// 日時、行番号等が異なっても、ログレベルとメッセージが同じログは集計する
+func TestDedup(t *testing.T) {
+	os.RemoveAll("test/dedup")
+	log := Log{}
+	log.Path = "test/dedup/error.log"
+	log.Format = "%l %L: %M"
+	log.Level = 7
+	log.Depth = 3
+	log.Dedup = true
+	l, err := log.MakeLog(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Error("db down")
+	l.Error("db down")
+	l.Error("db down")
+	l.Warn("db down")
+	log.Flush()
+
+	buf, _ := ioutil.ReadFile("test/dedup/error.log")
+	lines := strings.Split(string(buf), "\n")
+	if len(lines) != 4 || !strings.HasSuffix(lines[0], " error: db down") || lines[1] != "message repeated 2 times" ||
+		!strings.HasSuffix(lines[2], " warn: db down") {
+		t.Fatal(string(buf))
+	}
+}
+
+// With で生成した Logger と、フィールドを付与する出力関数
+func TestWith(t *testing.T) {
+	os.RemoveAll("test/with")
+	log := Log{}
+	log.Path = "test/with/error.log"
+	log.Format = "%f(%m) %L: %M [%F]"
+	log.Level = 6
+	log.Depth = 3
+	if _, err := log.MakeLog(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	l := log.With("request_id", "abc")
+	l.Errorw("not found", "path", "/a b", "status", 404)
+	l.With("user", "root").Infof("%d files", 2)
+	l.Debugw("Hello World")
+	log.Warnw("Hello World", "err", fmt.Errorf("timeout"), 1)
+	log.Error("Hello World")
+
+	buf, _ := ioutil.ReadFile("test/with/error.log")
+	want := `errorlog_test.go(TestWith) error: not found [request_id=abc path="/a b" status=404]
+errorlog_test.go(TestWith) info: 2 files [request_id=abc user=root]
+errorlog_test.go(TestWith) warn: Hello World [err=timeout !BADKEY=1]
+errorlog_test.go(TestWith) error: Hello World []
+`
+	if string(buf) != want {
+		t.Fatal(string(buf))
+	}
+
+	// %F がない場合は、メッセージの後ろへ付与する
+	log.Format = "%L: %M"
+	l.Errorw("not found", "path", "/")
+	buf, _ = ioutil.ReadFile("test/with/error.log")
+	if !strings.HasSuffix(string(buf), "error: not found request_id=abc path=/\n") {
+		t.Fatal(string(buf))
+	}
+}
+
+func TestJSON(t *testing.T) {
+	os.RemoveAll("test/json")
+	log := Log{}
+	log.Path = "test/json/error.log"
+	log.Encoding = EncodingJSON
+	log.Binname = "app"
+	log.Level = 6
+	log.Depth = 3
+	log.Newline = true
+	log.Trim = true
+	if _, err := log.MakeLog(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	log.Error("line1\nline2\t\"quoted\" \\ \x01")
+	log.With("request_id", "abc").Warnw("not found", "status", 404, "ok", false, "took", 1.5, "err", fmt.Errorf("timeout"), "tags", []string{"a", "b"}, "nil", nil)
+	slog.New(log.Handler()).Info("Hello World", slog.Group("req", "path", "/"))
+
+	buf, _ := ioutil.ReadFile("test/json/error.log")
+	lines := strings.Split(strings.TrimSuffix(string(buf), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatal(string(buf))
+	}
+	var entries []map[string]interface{}
+	for _, line := range lines {
+		var v map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &v); err != nil {
+			t.Fatal(err, line)
+		}
+		entries = append(entries, v)
+	}
+
+	e := entries[0]
+	if _, err := time.Parse(time.RFC3339Nano, e["time"].(string)); err != nil {
+		t.Fatal(err)
+	}
+	if e["level"] != "error" || e["level_num"] != 3.0 || e["pid"] != float64(os.Getpid()) || e["bin"] != "app" ||
+		e["file"] != "errorlog_test.go" || e["func"] != "TestJSON" || e["line"] == 0.0 ||
+		e["msg"] != "line1\nline2\t\"quoted\" \\ \x01" || e["fields"] != nil {
+		t.Fatal(lines[0])
+	}
+	if !strings.Contains(lines[0], `"msg":"line1\nline2\t\"quoted\" \\ \u0001"}`) {
+		t.Fatal(lines[0])
+	}
+	if !strings.HasSuffix(lines[1], `"msg":"not found","fields":{"request_id":"abc","status":404,"ok":false,"took":1.5,"err":"timeout","tags":["a","b"],"nil":null}}`) {
+		t.Fatal(lines[1])
+	}
+	if !strings.HasSuffix(lines[2], `"func":"TestJSON","line":`+fmt.Sprint(entries[2]["line"])+`,"msg":"Hello World","fields":{"req.path":"/"}}`) {
+		t.Fatal(lines[2])
+	}
+
+	if err := log.Apply(&Log{Encoding: "xml"}); err == nil {
+		t.Fatal("unsupported encoding was accepted")
+	}
+}
+
+func TestLogfmt(t *testing.T) {
+	os.RemoveAll("test/logfmt")
+	log := Log{}
+	log.Path = "test/logfmt/error.log"
+	log.Encoding = EncodingLogfmt
+	log.Format = "%D %T %f(%m:%l) %L: %M"
+	log.Binname = "app"
+	log.Level = 6
+	log.Depth = 3
+	log.Newline = true
+	if _, err := log.MakeLog(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	log.Error("a.out not found\n")
+	log.With("request_id", "abc").Warnw("timeout", "path", "/a b")
+	log.Format = "%L %F %M"
+	log.Infow("Hello", "user", "root")
+	log.Info("Hello")
+	log.Format = ""
+	log.Error("Hello")
+
+	buf, _ := ioutil.ReadFile("test/logfmt/error.log")
+	re := regexp.MustCompile(`time=\S+ `)
+	want := `caller=errorlog_test.go:\d+ func=TestLogfmt level=error msg="a.out not found\\n"
+caller=errorlog_test.go:\d+ func=TestLogfmt level=warn msg=timeout request_id=abc path="/a b"
+level=info user=root msg=Hello
+level=info msg=Hello
+level=error caller=errorlog_test.go:\d+ msg=Hello
+`
+	out := re.ReplaceAllString(string(buf), "")
+	if !regexp.MustCompile(`^`+want+`$`).MatchString(out) || strings.Count(string(buf), "time=") != 3 {
+		t.Fatal(string(buf))
+	}
+}
+
+func TestVModule(t *testing.T) {
+	os.RemoveAll("test/vmodule")
+	log := Log{}
+	log.Path = "test/vmodule/error.log"
+	log.Format = "%f(%m) %L: %M"
+	log.Level = LevelWarn
+	log.Depth = 3
+	log.VModule = "db*=7, errorlog_test=debug"
+	if _, err := log.MakeLog(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		log.Debugf("debug %d", i)
+	}
+	slog.New(log.Handler()).Debug("slog")
+	// パッケージのパスの末尾に一致させる。先に一致した指定を使用する
+	src := &Log{Format: log.Format, Level: LevelWarn, VModule: "logger/errorlog=3,errorlog_test=7"}
+	src.Path = log.Path
+	if err := log.Apply(src); err != nil {
+		t.Fatal(err)
+	}
+	log.Warn("warn")
+	log.Error("error")
+	// VModule に一致しない場合は Level を使用する
+	src.Level, src.VModule = LevelInfo, "db*=7"
+	log.Apply(src)
+	log.Info("info")
+	log.Debug("debug")
+	log.OutputForPC(LevelDebug, "db.go", "Query", 1, "query")
+
+	buf, _ := ioutil.ReadFile("test/vmodule/error.log")
+	want := `errorlog_test.go(TestVModule) debug: debug 0
+errorlog_test.go(TestVModule) debug: debug 1
+errorlog_test.go(TestVModule) debug: slog
+errorlog_test.go(TestVModule) error: error
+errorlog_test.go(TestVModule) info: info
+db.go(Query) debug: query
+`
+	if string(buf) != want {
+		t.Fatal(string(buf))
+	}
+
+	for _, s := range []string{"db", "=7", "db=verbose", "[=7"} {
+		if _, err := ParseVModule(s); err == nil {
+			t.Fatalf("%s: error expected", s)
+		}
+	}
+}
+
+func TestLevelHandler(t *testing.T) {
+	log := Log{}
+	log.Level = LevelWarn
+	if _, err := log.MakeLog(nil); err != nil {
+		t.Fatal(err)
+	}
+	h := log.LevelHandler()
+	do := func(method, query string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, "/level?"+query, nil))
+		var v map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &v)
+		return w.Code, v
+	}
+
+	if code, v := do("GET", ""); code != 200 || v["level"] != "warn" || v["level_num"] != 4.0 || v["revert_at"] != nil {
+		t.Fatal(code, v)
+	}
+	// 期間を指定した場合は、変更前のログレベルへ戻す
+	if code, v := do("POST", "level=debug&vmodule=db*=info&for=50ms"); code != 200 || v["level"] != "debug" || v["vmodule"] != "db*=info" || v["revert_at"] == nil {
+		t.Fatal(code, v)
+	}
+	if code, _ := do("PUT", "level=error&for=50ms"); code != 200 || log.GetLevel() != LevelError {
+		t.Fatal(code, log.GetLevel())
+	}
+	time.Sleep(200 * time.Millisecond)
+	if log.GetLevel() != LevelWarn || log.GetVModule() != "" {
+		t.Fatal(log.GetLevel(), log.GetVModule())
+	}
+
+	// 期間の経過前に SetLevel, シグナルで変更した場合は、変更後のログレベルを維持する
+	do("POST", "level=debug&for=50ms")
+	log.SetLevel(LevelInfo)
+	time.Sleep(100 * time.Millisecond)
+	if log.GetLevel() != LevelInfo {
+		t.Fatal(log.GetLevel())
+	}
+	do("POST", "level=debug&for=50ms")
+	log.stepLevel(-1)
+	time.Sleep(100 * time.Millisecond)
+	if log.GetLevel() != LevelInfo {
+		t.Fatal(log.GetLevel())
+	}
+	log.SetLevel(LevelWarn)
+
+	for _, query := range []string{"level=verbose", "vmodule=db", "level=info&for=-1s"} {
+		if code, _ := do("POST", query); code != 400 {
+			t.Fatal(query, code)
+		}
+	}
+	if code, _ := do("DELETE", ""); code != 405 {
+		t.Fatal(code)
+	}
+	if log.GetLevel() != LevelWarn {
+		t.Fatal(log.GetLevel())
+	}
+
+	// ログ出力中にログレベルを変更しても競合しない
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			log.Debug("debug")
+		}
+		close(done)
+	}()
+	for i := 0; i < 100; i++ {
+		log.SetLevel(i % 8)
+	}
+	<-done
+	if err := log.SetLevel(8); err == nil {
+		t.Fatal("invalid level was accepted")
+	}
+	log.SetLevel(LevelDebug)
+	if log.stepLevel(1) != LevelDebug || log.stepLevel(-1) != LevelInfo {
+		t.Fatal(log.GetLevel())
+	}
+}
@@ -0,0 +1,279 @@
+package errorlog
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ochipin/logger"
+)
+
+// With, Errorw 等で付与するフィールド
+type field struct {
+	key   string
+	value interface{}
+}
+
+// キーと値を交互に並べた kv を、fields の後ろへ追加する。キーが文字列でない場合、値が足りない場合は "!BADKEY" をキーとする
+func appendFields(fields []field, kv []interface{}) []field {
+	// 親のフィールドを書き換えないよう、容量を超えて追加する
+	fields = fields[:len(fields):len(fields)]
+	for i := 0; i < len(kv); i++ {
+		key, ok := kv[i].(string)
+		if !ok || i+1 == len(kv) {
+			fields = append(fields, field{"!BADKEY", kv[i]})
+			continue
+		}
+		fields = append(fields, field{key, kv[i+1]})
+		i++
+	}
+	return fields
+}
+
+// フィールドを key=value 形式で、空白区切りに連結する
+func encodeFields(fields []field) string {
+	var b []byte
+	for _, f := range fields {
+		b = logger.AppendLogfmt(b, f.key, formatValue(f.value))
+	}
+	return string(b)
+}
+
+// フィールドの値を文字列に変換する
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(v)
+}
+
+// フォーマットの %M をメッセージに、%F をフィールドに置き換える。
+// Format に %F がない場合は、メッセージの後ろへフィールドを付与する。JSON 形式の場合は、mes の後ろへメッセージとフィールドを付与する。
+// l.mu をロックした状態で呼び出すこと
+func (l *Log) fill(mes, msg string, fields []field) string {
+	if l.Encoding == EncodingJSON {
+		return fillJSON(mes, msg, fields)
+	}
+	f := encodeFields(fields)
+	if l.Encoding == EncodingLogfmt {
+		return fillLogfmt(mes, msg, f, strings.Contains(l.logFormat(), "%F"))
+	}
+	if f != "" && !strings.Contains(l.Format, "%F") {
+		msg += " " + f
+	}
+	return strings.NewReplacer("%M", msg, "%F", f).Replace(mes)
+}
+
+// ログを出力する。Dedup の重複判定には、日時等を含む mes ではなく、ログレベル、メッセージ、フィールドを使用する。
+// l.mu をロックした状態で呼び出すこと
+func (l *Log) print(level int, mes, msg string, fields []field) {
+	key := LevelName(level) + ": " + msg
+	if len(fields) != 0 {
+		key += " " + encodeFields(fields)
+	}
+	l.PrintKey(key, l.fill(mes, msg, fields))
+}
+
+// フィールドを付与してログを出力する。Errorw 等から呼び出されるため、Depth より1つ深い階層を呼び出し元とする
+func (l *Log) outputw(level int, fields []field, msg string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.Depth++
+	mes, err := l.message(level)
+	l.Depth--
+	if err == nil || level == LevelEmerg {
+		l.print(level, mes, msg, fields)
+	}
+}
+
+// With : kv のフィールドを全てのログへ付与する Logger を生成する。kv はキーと値を交互に指定する。
+// 生成した Logger は、ログファイル、ログローテーション等を l と共有する
+// ex) log.With("request_id", id).Errorw("not found", "path", path)
+func (l *Log) With(kv ...interface{}) Logger {
+	return &child{log: l, fields: appendFields(nil, kv)}
+}
+
+// Emergw : ログレベル0。kv のフィールドを付与して出力し、リターンコード127でプログラムを強制終了する
+func (l *Log) Emergw(msg string, kv ...interface{}) {
+	l.outputw(LevelEmerg, appendFields(nil, kv), msg)
+	os.Exit(127)
+}
+
+// Alertw : ログレベル1。kv のフィールドを付与して出力する
+func (l *Log) Alertw(msg string, kv ...interface{}) {
+	l.outputw(LevelAlert, appendFields(nil, kv), msg)
+}
+
+// Critw : ログレベル2。kv のフィールドを付与して出力する
+func (l *Log) Critw(msg string, kv ...interface{}) {
+	l.outputw(LevelCrit, appendFields(nil, kv), msg)
+}
+
+// Errorw : ログレベル3。kv のフィールドを付与して出力する
+func (l *Log) Errorw(msg string, kv ...interface{}) {
+	l.outputw(LevelError, appendFields(nil, kv), msg)
+}
+
+// Warnw : ログレベル4。kv のフィールドを付与して出力する
+func (l *Log) Warnw(msg string, kv ...interface{}) {
+	l.outputw(LevelWarn, appendFields(nil, kv), msg)
+}
+
+// Noticew : ログレベル5。kv のフィールドを付与して出力する
+func (l *Log) Noticew(msg string, kv ...interface{}) {
+	l.outputw(LevelNotice, appendFields(nil, kv), msg)
+}
+
+// Infow : ログレベル6。kv のフィールドを付与して出力する
+func (l *Log) Infow(msg string, kv ...interface{}) {
+	l.outputw(LevelInfo, appendFields(nil, kv), msg)
+}
+
+// Debugw : ログレベル7。kv のフィールドを付与して出力する
+func (l *Log) Debugw(msg string, kv ...interface{}) {
+	l.outputw(LevelDebug, appendFields(nil, kv), msg)
+}
+
+// With で生成する Logger。全てのログを、フィールドを付与して親のログ管理構造体へ出力する
+type child struct {
+	log    *Log
+	fields []field
+}
+
+func (c *child) Emerg(v ...interface{}) {
+	c.log.outputw(LevelEmerg, c.fields, fmt.Sprint(v...))
+	os.Exit(127)
+}
+
+func (c *child) Emergf(format string, v ...interface{}) {
+	c.log.outputw(LevelEmerg, c.fields, fmt.Sprintf(format, v...))
+	os.Exit(127)
+}
+
+func (c *child) Emergw(msg string, kv ...interface{}) {
+	c.log.outputw(LevelEmerg, appendFields(c.fields, kv), msg)
+	os.Exit(127)
+}
+
+func (c *child) Alert(v ...interface{}) {
+	c.log.outputw(LevelAlert, c.fields, fmt.Sprint(v...))
+}
+
+func (c *child) Alertf(format string, v ...interface{}) {
+	c.log.outputw(LevelAlert, c.fields, fmt.Sprintf(format, v...))
+}
+
+func (c *child) Alertw(msg string, kv ...interface{}) {
+	c.log.outputw(LevelAlert, appendFields(c.fields, kv), msg)
+}
+
+func (c *child) Crit(v ...interface{}) {
+	c.log.outputw(LevelCrit, c.fields, fmt.Sprint(v...))
+}
+
+func (c *child) Critf(format string, v ...interface{}) {
+	c.log.outputw(LevelCrit, c.fields, fmt.Sprintf(format, v...))
+}
+
+func (c *child) Critw(msg string, kv ...interface{}) {
+	c.log.outputw(LevelCrit, appendFields(c.fields, kv), msg)
+}
+
+func (c *child) Error(v ...interface{}) {
+	c.log.outputw(LevelError, c.fields, fmt.Sprint(v...))
+}
+
+func (c *child) Errorf(format string, v ...interface{}) {
+	c.log.outputw(LevelError, c.fields, fmt.Sprintf(format, v...))
+}
+
+func (c *child) Errorw(msg string, kv ...interface{}) {
+	c.log.outputw(LevelError, appendFields(c.fields, kv), msg)
+}
+
+func (c *child) Warn(v ...interface{}) {
+	c.log.outputw(LevelWarn, c.fields, fmt.Sprint(v...))
+}
+
+func (c *child) Warnf(format string, v ...interface{}) {
+	c.log.outputw(LevelWarn, c.fields, fmt.Sprintf(format, v...))
+}
+
+func (c *child) Warnw(msg string, kv ...interface{}) {
+	c.log.outputw(LevelWarn, appendFields(c.fields, kv), msg)
+}
+
+func (c *child) Notice(v ...interface{}) {
+	c.log.outputw(LevelNotice, c.fields, fmt.Sprint(v...))
+}
+
+func (c *child) Noticef(format string, v ...interface{}) {
+	c.log.outputw(LevelNotice, c.fields, fmt.Sprintf(format, v...))
+}
+
+func (c *child) Noticew(msg string, kv ...interface{}) {
+	c.log.outputw(LevelNotice, appendFields(c.fields, kv), msg)
+}
+
+func (c *child) Info(v ...interface{}) {
+	c.log.outputw(LevelInfo, c.fields, fmt.Sprint(v...))
+}
+
+func (c *child) Infof(format string, v ...interface{}) {
+	c.log.outputw(LevelInfo, c.fields, fmt.Sprintf(format, v...))
+}
+
+func (c *child) Infow(msg string, kv ...interface{}) {
+	c.log.outputw(LevelInfo, appendFields(c.fields, kv), msg)
+}
+
+func (c *child) Debug(v ...interface{}) {
+	c.log.outputw(LevelDebug, c.fields, fmt.Sprint(v...))
+}
+
+func (c *child) Debugf(format string, v ...interface{}) {
+	c.log.outputw(LevelDebug, c.fields, fmt.Sprintf(format, v...))
+}
+
+func (c *child) Debugw(msg string, kv ...interface{}) {
+	c.log.outputw(LevelDebug, appendFields(c.fields, kv), msg)
+}
+
+// With : 親のフィールドに kv のフィールドを加えた Logger を生成する
+func (c *child) With(kv ...interface{}) Logger {
+	return &child{log: c.log, fields: appendFields(c.fields, kv)}
+}
+
+// Output : depth は errorlog.Log と同様に、3 で Output の呼び出し元を指す
+func (c *child) Output(level, depth int, format string, v ...interface{}) {
+	c.log.mu.Lock()
+	defer c.log.mu.Unlock()
+	oldDepth := c.log.Depth
+	c.log.Depth = depth
+	if mes, err := c.log.message(level); err == nil {
+		c.log.print(level, mes, fmt.Sprintf(format, v...), c.fields)
+	}
+	c.log.Depth = oldDepth
+}
+
+func (c *child) OutputForPC(level int, filename, funcname string, line int, format string, v ...interface{}) {
+	c.log.mu.Lock()
+	defer c.log.mu.Unlock()
+	if mes, err := c.log.messageForPC(level, filename, funcname, line); err == nil {
+		c.log.print(level, mes, fmt.Sprintf(format, v...), c.fields)
+	}
+}
+
+// GetDepth : 親の Depth 値を取得する
+func (c *child) GetDepth() int { return c.log.GetDepth() }
+
+// SetDepth : 親の Depth 値を設定する
+func (c *child) SetDepth(depth int) { c.log.SetDepth(depth) }
@@ -0,0 +1,212 @@
+package errorlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Encoding に指定できるログの形式
+const (
+	EncodingText   = "text"   // Format に沿って出力する
+	EncodingJSON   = "json"   // 1行1オブジェクトの JSON 形式で出力する
+	EncodingLogfmt = "logfmt" // Format の指定子を、logfmt 形式の key=value で出力する
+)
+
+// Encoding に指定された値を検証する
+func checkEncoding(encoding string) error {
+	switch encoding {
+	case "", EncodingText, EncodingJSON, EncodingLogfmt:
+		return nil
+	}
+	return fmt.Errorf("\"%s\" encoding is not supported", encoding)
+}
+
+// JSON 形式のログを組み立てるバッファ。ログ出力ごとのメモリ確保を減らすため使い回す
+var jsonPool = sync.Pool{New: func() interface{} { b := make([]byte, 0, 512); return &b }}
+
+// JSON 形式のログの、メッセージより前の部分を生成する。メッセージ、フィールドは fill で付与する
+// ex) {"time":"2018-03-21T21:22:02.123456789+09:00","level":"error","level_num":3,"pid":1234,"bin":"app","file":"main.go","func":"main","line":11,"msg":
+func (l *Log) formatJSON(levelname, filename, funcname string, linenum int, now time.Time) string {
+	bp := jsonPool.Get().(*[]byte)
+	b := append((*bp)[:0], `{"time":"`...)
+	b = now.AppendFormat(b, time.RFC3339Nano)
+	b = append(b, `","level":"`...)
+	b = append(b, levelname...)
+	b = append(b, `","level_num":`...)
+	b = strconv.AppendInt(b, int64(levelNum(levelname)), 10)
+	b = append(b, `,"pid":`...)
+	if l.pid == "" {
+		b = append(b, '0')
+	} else {
+		b = append(b, l.pid...)
+	}
+	b = append(b, `,"bin":`...)
+	b = appendJSONString(b, l.Binname)
+	b = append(b, `,"file":`...)
+	b = appendJSONString(b, filename)
+	b = append(b, `,"func":`...)
+	b = appendJSONString(b, funcname)
+	b = append(b, `,"line":`...)
+	b = strconv.AppendInt(b, int64(linenum), 10)
+	b = append(b, `,"msg":`...)
+	s := string(b)
+	*bp = b
+	jsonPool.Put(bp)
+	return s
+}
+
+// formatJSON で生成した mes の後ろへ、メッセージとフィールドを付与して JSON オブジェクトを閉じる
+// ex) ..."msg":"not found","fields":{"path":"/index.html"}}
+func fillJSON(mes, msg string, fields []field) string {
+	bp := jsonPool.Get().(*[]byte)
+	b := append((*bp)[:0], mes...)
+	b = appendJSONString(b, msg)
+	if len(fields) > 0 {
+		b = append(b, `,"fields":{`...)
+		for i, f := range fields {
+			if i != 0 {
+				b = append(b, ',')
+			}
+			b = appendJSONString(b, f.key)
+			b = append(b, ':')
+			b = appendJSONValue(b, f.value)
+		}
+		b = append(b, '}')
+	}
+	b = append(b, '}')
+	s := string(b)
+	*bp = b
+	jsonPool.Put(bp)
+	return s
+}
+
+// ログレベル名からログレベル値を取得する
+func levelNum(name string) int {
+	for level, v := range levelNames {
+		if v == name {
+			return level
+		}
+	}
+	return -1
+}
+
+// フィールドの値を JSON の値として追記する。JSON に変換できない値は、fmt.Sprint で文字列化する
+func appendJSONValue(b []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case nil:
+		return append(b, "null"...)
+	case string:
+		return appendJSONString(b, v)
+	case bool:
+		return strconv.AppendBool(b, v)
+	case int:
+		return strconv.AppendInt(b, int64(v), 10)
+	case int8:
+		return strconv.AppendInt(b, int64(v), 10)
+	case int16:
+		return strconv.AppendInt(b, int64(v), 10)
+	case int32:
+		return strconv.AppendInt(b, int64(v), 10)
+	case int64:
+		return strconv.AppendInt(b, v, 10)
+	case uint:
+		return strconv.AppendUint(b, uint64(v), 10)
+	case uint8:
+		return strconv.AppendUint(b, uint64(v), 10)
+	case uint16:
+		return strconv.AppendUint(b, uint64(v), 10)
+	case uint32:
+		return strconv.AppendUint(b, uint64(v), 10)
+	case uint64:
+		return strconv.AppendUint(b, v, 10)
+	case float32:
+		return appendJSONFloat(b, float64(v), 32)
+	case float64:
+		return appendJSONFloat(b, v, 64)
+	case time.Time:
+		b = append(b, '"')
+		b = v.AppendFormat(b, time.RFC3339Nano)
+		return append(b, '"')
+	case time.Duration:
+		return appendJSONString(b, v.String())
+	case error:
+		return appendJSONString(b, v.Error())
+	case json.Marshaler:
+		if buf, err := v.MarshalJSON(); err == nil && json.Valid(buf) {
+			return append(b, buf...)
+		}
+	case fmt.Stringer:
+		return appendJSONString(b, v.String())
+	default:
+		if buf, err := json.Marshal(v); err == nil {
+			return append(b, buf...)
+		}
+	}
+	return appendJSONString(b, fmt.Sprint(v))
+}
+
+// 浮動小数点数を追記する。JSON で表現できない NaN, Inf は文字列とする
+func appendJSONFloat(b []byte, f float64, bits int) []byte {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return appendJSONString(b, strconv.FormatFloat(f, 'g', -1, bits))
+	}
+	return strconv.AppendFloat(b, f, 'g', -1, bits)
+}
+
+const hexDigits = "0123456789abcdef"
+
+// 文字列を JSON の文字列としてエスケープして追記する。制御文字は全てエスケープするため、
+// 出力に改行、タブが含まれることはなく、Newline, Tabspace, Trim を指定しても JSON が壊れない
+func appendJSONString(b []byte, s string) []byte {
+	b = append(b, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' {
+				i++
+				continue
+			}
+			b = append(b, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				b = append(b, '\\', c)
+			case '\n':
+				b = append(b, '\\', 'n')
+			case '\r':
+				b = append(b, '\\', 'r')
+			case '\t':
+				b = append(b, '\\', 't')
+			default:
+				b = append(b, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		// 不正な UTF-8 は U+FFFD に置き換える。U+2028, U+2029 は JavaScript で改行とみなされるためエスケープする
+		if r == utf8.RuneError && size == 1 {
+			b = append(b, s[start:i]...)
+			b = append(b, `\ufffd`...)
+			i += size
+			start = i
+			continue
+		}
+		if r == '\u2028' || r == '\u2029' {
+			b = append(b, s[start:i]...)
+			b = append(b, '\\', 'u', '2', '0', '2', hexDigits[r&0xf])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	b = append(b, s[start:]...)
+	return append(b, '"')
+}
@@ -0,0 +1,194 @@
+package errorlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// 変更前のログレベルと VModule
+type levelState struct {
+	level   int
+	vmodule string
+}
+
+// GetLevel : 動作中のログレベルを取得する。ログ出力と排他せずに取得できる
+func (l *Log) GetLevel() int {
+	return int(l.level.Load())
+}
+
+// SetLevel : 動作中のログレベルを変更する。ログ出力中に呼び出してもよい。
+// LevelHandler で期間を指定して変更していた場合、変更前のログレベルへは戻さない
+func (l *Log) SetLevel(level int) error {
+	if LevelName(level) == "" {
+		return fmt.Errorf("please log level set 0-7")
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stopRevert()
+	l.Level = level
+	l.level.Store(int32(level))
+	return nil
+}
+
+// GetVModule : 動作中の VModule を取得する
+func (l *Log) GetVModule() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.VModule
+}
+
+// SetVModule : 動作中の VModule を変更する。呼び出し元ごとのキャッシュは破棄される
+func (l *Log) SetVModule(vmodule string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.setVModule(vmodule)
+}
+
+// ログレベルと VModule を変更する。d が 0 より大きい場合は、d 経過後に変更前の値へ戻す。
+// 元に戻す前に再度変更した場合は、最初に変更する前の値へ戻す
+func (l *Log) setLevels(level int, vmodule string, d time.Duration) error {
+	if LevelName(level) == "" {
+		return fmt.Errorf("please log level set 0-7")
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	prev := levelState{l.GetLevel(), l.VModule}
+	if err := l.setVModule(vmodule); err != nil {
+		return err
+	}
+	l.Level = level
+	l.level.Store(int32(level))
+
+	if d <= 0 {
+		l.stopRevert()
+		return nil
+	}
+	if l.revert == nil {
+		l.prev = prev
+	} else {
+		l.revert.Stop()
+	}
+	l.revertAt = time.Now().Add(d)
+	var timer *time.Timer
+	timer = time.AfterFunc(d, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		// 既に変更、停止されたタイマーの場合は何もしない
+		if l.revert != timer {
+			return
+		}
+		l.Level = l.prev.level
+		l.level.Store(int32(l.prev.level))
+		l.setVModule(l.prev.vmodule)
+		l.revert = nil
+	})
+	l.revert = timer
+	return nil
+}
+
+// ログレベルを元に戻すタイマーを停止する。l.mu をロックした状態で呼び出すこと
+func (l *Log) stopRevert() {
+	if l.revert != nil {
+		l.revert.Stop()
+		l.revert = nil
+	}
+}
+
+// ログレベルを1段階変更する。delta が正の場合は詳細に、負の場合は簡潔にする。
+// 期間を指定した変更は取り消し、変更後のログレベルを維持する
+func (l *Log) stepLevel(delta int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stopRevert()
+	level := l.GetLevel() + delta
+	if level < LevelEmerg {
+		level = LevelEmerg
+	} else if level > LevelDebug {
+		level = LevelDebug
+	}
+	l.Level = level
+	l.level.Store(int32(level))
+	return level
+}
+
+// LevelHandler で返却するログレベルの状態
+type levelStatus struct {
+	Level    string     `json:"level"`               // ログレベル名
+	LevelNum int        `json:"level_num"`           // ログレベル値
+	VModule  string     `json:"vmodule"`             // パッケージ、ファイルごとのログレベル
+	Rules    []vrule    `json:"rules"`               // VModule を解析したもの
+	RevertAt *time.Time `json:"revert_at,omitempty"` // 変更前のログレベルへ戻す日時
+}
+
+// LevelHandler で返却する VModule の1つの指定
+type vrule struct {
+	Pattern string `json:"pattern"`
+	Level   string `json:"level"`
+}
+
+// LevelHandler : ログレベルを参照、変更する http.Handler を返却する。
+// GET は現在のログレベルを JSON で返却し、POST, PUT は level, vmodule パラメータの値へ変更する。
+// for パラメータに "10m" 等の期間を指定した場合は、期間の経過後に変更前のログレベルへ戻す
+// ex) curl -X POST 'http://localhost:8080/debug/level?level=debug&vmodule=db*=7&for=10m'
+func (l *Log) LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPost, http.MethodPut:
+			if err := l.changeLevel(r); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST, PUT")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(l.levelStatus())
+	})
+}
+
+// リクエストのパラメータに従い、ログレベルを変更する。指定されなかったパラメータは変更しない
+func (l *Log) changeLevel(r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	level, vmodule := l.GetLevel(), l.GetVModule()
+	if v, ok := r.Form["level"]; ok {
+		n, err := ParseLevel(v[0])
+		if err != nil {
+			return err
+		}
+		level = n
+	}
+	if v, ok := r.Form["vmodule"]; ok {
+		vmodule = v[0]
+	}
+	var d time.Duration
+	if v := r.Form.Get("for"); v != "" {
+		var err error
+		if d, err = time.ParseDuration(v); err != nil || d <= 0 {
+			return fmt.Errorf("\"%s\" is not positive duration", v)
+		}
+	}
+	return l.setLevels(level, vmodule, d)
+}
+
+// 現在のログレベルの状態を取得する
+func (l *Log) levelStatus() levelStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	level := l.GetLevel()
+	s := levelStatus{Level: LevelName(level), LevelNum: level, VModule: l.VModule, Rules: []vrule{}}
+	for _, r := range l.vrules {
+		s.Rules = append(s.Rules, vrule{r.Pattern, LevelName(r.Level)})
+	}
+	if l.revert != nil {
+		at := l.revertAt
+		s.RevertAt = &at
+	}
+	return s
+}
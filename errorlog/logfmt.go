@@ -0,0 +1,86 @@
+package errorlog
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ochipin/logger"
+)
+
+// logfmt 形式で使用する指定子
+var matchDirective = regexp.MustCompile(`%[DTLfmlMFbp]`)
+
+// Format が空文字列の場合に、logfmt 形式で使用するフォーマット
+const defaultLogfmt = "%D %T %L %f:%l %M"
+
+// 使用するフォーマットを取得する。logfmt 形式で Format が空文字列の場合は、既定のフォーマットとする
+func (l *Log) logFormat() string {
+	if l.Encoding == EncodingLogfmt && l.Format == "" {
+		return defaultLogfmt
+	}
+	return l.Format
+}
+
+// Format の指定子を、出現順に key=value へ変換する。指定子以外の文字列は出力しない。
+// %D と %T は time、%f と %l は caller として1つにまとめる。%M, %F はそのまま返却し、fill で置き換える
+// ex) "%D %T %f(%m:%l) %L: %M" ---> time=2018-03-21T21:22:02.123456789+09:00 caller=main.go:11 func=main level=error msg=%M
+func (l *Log) formatLogfmt(levelname, filename, funcname string, linenum int, now time.Time) string {
+	var b []byte
+	var done [128]bool
+	for _, d := range matchDirective.FindAllString(l.logFormat(), -1) {
+		c := d[1]
+		switch c {
+		case 'T':
+			c = 'D'
+		case 'l':
+			c = 'f'
+		}
+		if done[c] {
+			continue
+		}
+		done[c] = true
+
+		switch c {
+		case 'D':
+			b = logger.AppendLogfmt(b, "time", now.Format(time.RFC3339Nano))
+		case 'L':
+			b = logger.AppendLogfmt(b, "level", levelname)
+		case 'f':
+			b = logger.AppendLogfmt(b, "caller", filename+":"+strconv.Itoa(linenum))
+		case 'm':
+			b = logger.AppendLogfmt(b, "func", funcname)
+		case 'p':
+			b = logger.AppendLogfmt(b, "pid", l.pid)
+		case 'b':
+			b = logger.AppendLogfmt(b, "bin", l.Binname)
+		case 'M':
+			b = appendToken(b, "msg=%M")
+		case 'F':
+			b = appendToken(b, "%F")
+		}
+	}
+	return string(b)
+}
+
+// b の後ろへ、空白で区切って s を追記する
+func appendToken(b []byte, s string) []byte {
+	if len(b) != 0 {
+		b = append(b, ' ')
+	}
+	return append(b, s...)
+}
+
+// formatLogfmt で生成した mes の %M をクオートしたメッセージに、%F をフィールドに置き換える。
+// hasF が false の場合はメッセージの後ろへフィールドを付与し、フィールドがない場合は %F を区切りの空白ごと取り除く
+func fillLogfmt(mes, msg, fields string, hasF bool) string {
+	msg = logger.LogfmtValue(msg)
+	if fields == "" {
+		return strings.NewReplacer("%M", msg, " %F", "", "%F ", "", "%F", "").Replace(mes)
+	}
+	if !hasF {
+		msg += " " + fields
+	}
+	return strings.NewReplacer("%M", msg, "%F", fields).Replace(mes)
+}
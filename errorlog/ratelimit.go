@@ -0,0 +1,79 @@
+package errorlog
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ochipin/logger"
+)
+
+// 呼び出し元ごとの出力の上限を超えた場合に、message が返却するエラー
+var errSiteLimit = errors.New("errorlog: call site rate limit exceeded")
+
+// 呼び出し元ごとの出力の制限
+type site struct {
+	limiter    logger.RateLimiter
+	filename   string
+	funcname   string
+	linenum    int
+	level      int    // 出力を制限したログのうち、最も重大なログレベル
+	suppressed uint64 // 出力を制限した、未報告のログの行数
+}
+
+// 呼び出し元ごとの出力の上限を超えていなければ true を返却する。l.mu をロックした状態で呼び出すこと
+func (l *Log) allowSite(level int, filename, funcname string, linenum int) bool {
+	if l.SiteLimit <= 0 {
+		return true
+	}
+	key := fmt.Sprintf("%s:%d", filename, linenum)
+	if l.sites == nil {
+		l.sites = map[string]*site{}
+	}
+	s, ok := l.sites[key]
+	if !ok {
+		s = &site{filename: filename, funcname: funcname, linenum: linenum}
+		l.sites[key] = s
+	}
+	s.limiter.Rate, s.limiter.Burst = l.SiteLimit, l.SiteBurst
+	if s.limiter.Allow(time.Now()) {
+		return true
+	}
+	if s.suppressed == 0 || level < s.level {
+		s.level = level
+	}
+	s.suppressed++
+	l.siteCount++
+	// 出力を制限したログの行数を、定期的に呼び出し元ごとに出力する
+	if l.siteTimer == nil {
+		wait := l.RateReport
+		if wait <= 0 {
+			wait = 10 * time.Second
+		}
+		l.siteTimer = time.AfterFunc(wait, l.siteReport)
+	}
+	return false
+}
+
+// 出力を制限した呼び出し元ごとに、"rate limit exceeded, suppressed N lines" を、呼び出し元の情報と共に出力する
+func (l *Log) siteReport() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.siteTimer = nil
+
+	var keys []string
+	for key, s := range l.sites {
+		if s.suppressed != 0 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	now := time.Now()
+	for _, key := range keys {
+		s := l.sites[key]
+		mes := l.format(LevelName(s.level), s.filename, s.funcname, s.linenum, now)
+		l.print(s.level, mes, fmt.Sprintf("rate limit exceeded, suppressed %d lines", s.suppressed), nil)
+		s.suppressed = 0
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package errorlog
+
+// NotifySignals : SIGUSR1, SIGUSR2 が存在しない環境では、何もしない
+func (l *Log) NotifySignals() (stop func()) {
+	return func() {}
+}
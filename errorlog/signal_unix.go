@@ -0,0 +1,37 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package errorlog
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// NotifySignals : SIGUSR1 を受信した場合はログレベルを1段階詳細に、SIGUSR2 を受信した場合は1段階簡潔にする。
+// 返却された関数を呼び出すと、シグナルの受信を停止する
+// ex) defer log.NotifySignals()()
+func (l *Log) NotifySignals() (stop func()) {
+	ch := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(ch, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for {
+			select {
+			case sig := <-ch:
+				if sig == syscall.SIGUSR1 {
+					l.stepLevel(1)
+				} else {
+					l.stepLevel(-1)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(ch)
+		close(done)
+	}
+}
@@ -0,0 +1,142 @@
+package errorlog
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// slog のログレベルに存在しない、syslog 形式のログレベルに対応する slog のログレベル値
+const (
+	SlogLevelNotice = slog.Level(2)  // notice
+	SlogLevelCrit   = slog.Level(12) // crit
+	SlogLevelAlert  = slog.Level(16) // alert
+	SlogLevelEmerg  = slog.Level(20) // emerg
+)
+
+// FromSlogLevel : slog のログレベルを、0-7 のログレベル値へ変換する
+func FromSlogLevel(level slog.Level) int {
+	switch {
+	case level < slog.LevelInfo:
+		return LevelDebug
+	case level < SlogLevelNotice:
+		return LevelInfo
+	case level < slog.LevelWarn:
+		return LevelNotice
+	case level < slog.LevelError:
+		return LevelWarn
+	case level < SlogLevelCrit:
+		return LevelError
+	case level < SlogLevelAlert:
+		return LevelCrit
+	case level < SlogLevelEmerg:
+		return LevelAlert
+	}
+	return LevelEmerg
+}
+
+// ToSlogLevel : 0-7 のログレベル値を、slog のログレベルへ変換する
+func ToSlogLevel(level int) slog.Level {
+	levels := []slog.Level{SlogLevelEmerg, SlogLevelAlert, SlogLevelCrit, slog.LevelError, slog.LevelWarn, SlogLevelNotice, slog.LevelInfo, slog.LevelDebug}
+	if level < 0 {
+		return SlogLevelEmerg
+	} else if level >= len(levels) {
+		return slog.LevelDebug
+	}
+	return levels[level]
+}
+
+// Handler 構造体は、errorlog のログ管理構造体へ出力する slog.Handler
+type Handler struct {
+	log   *Log
+	attrs []field // WithAttrs で追加された属性
+	group string  // WithGroup で指定されたグループ名。属性名の前に付与する ex) "req."
+}
+
+// Handler : ログ管理構造体へ出力する slog.Handler を生成する。
+// %f, %m, %l には Depth ではなく、slog のログ出力関数の呼び出し元が使用される。
+// 属性は、%F、または %F がない場合はメッセージの後ろへ key=value 形式で出力する
+// ex) slog.New(log.Handler()).Info("Hello World", "user", "root")
+func (l *Log) Handler() *Handler {
+	return &Handler{log: l}
+}
+
+// Enabled : 指定されたログレベルが出力対象か判定する
+func (h *Handler) Enabled(_ context.Context, level slog.Level) bool {
+	h.log.mu.Lock()
+	defer h.log.mu.Unlock()
+	return FromSlogLevel(level) <= h.log.maxLevel()
+}
+
+// Handle : ログを出力する。emerg に変換されたログを出力しても、プログラムは終了しない
+func (h *Handler) Handle(_ context.Context, r slog.Record) error {
+	// 1. ログ出力関数の呼び出し元を取得する
+	filename, funcname, linenum := "???", "???", 0
+	var function, file string
+	if r.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		function, file = frame.Function, frame.File
+		funcname = frame.Function[strings.LastIndex(frame.Function, ".")+1:]
+		filename = frame.File[strings.LastIndex(frame.File, "/")+1:]
+		linenum = frame.Line
+	}
+	// 2. 属性をフィールドへ変換する。グループの属性は、グループ名を付与して展開する
+	fields := h.attrs[:len(h.attrs):len(h.attrs)]
+	r.Attrs(func(a slog.Attr) bool {
+		fields = appendAttr(fields, h.group, a)
+		return true
+	})
+	now := r.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	h.log.mu.Lock()
+	defer h.log.mu.Unlock()
+	max := h.log.GetLevel()
+	if len(h.log.vrules) != 0 {
+		max = h.log.levelFor(function, file)
+	}
+	if mes, err := h.log.messageAt(max, FromSlogLevel(r.Level), filename, funcname, linenum, now); err == nil {
+		h.log.print(FromSlogLevel(r.Level), mes, r.Message, fields)
+	}
+	return nil
+}
+
+// WithAttrs : 指定された属性を、全てのログへ付与する slog.Handler を生成する
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	fields := h.attrs[:len(h.attrs):len(h.attrs)]
+	for _, a := range attrs {
+		fields = appendAttr(fields, h.group, a)
+	}
+	return &Handler{log: h.log, attrs: fields, group: h.group}
+}
+
+// WithGroup : 以降に追加される属性名の前に、グループ名を付与する slog.Handler を生成する
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &Handler{log: h.log, attrs: h.attrs, group: h.group + name + "."}
+}
+
+// 属性をフィールドへ追加する。グループの属性は "group.key" をキーとして展開する
+func appendAttr(fields []field, prefix string, a slog.Attr) []field {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return fields
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		// 属性名が空のグループは、グループ名を付与せずに展開する
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			fields = appendAttr(fields, prefix, ga)
+		}
+		return fields
+	}
+	return append(fields, field{prefix + a.Key, a.Value.Any()})
+}
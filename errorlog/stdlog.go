@@ -0,0 +1,29 @@
+package errorlog
+
+import (
+	"log"
+
+	"github.com/ochipin/logger"
+)
+
+// StdLogger : 指定したログレベルで出力する *log.Logger を生成する。
+// %f, %m, %l には log パッケージの呼び出し元が使用され、SetPrefix, SetFlags で指定したプレフィックス、日時等は出力前に取り除かれる
+// ex) http.Server{ErrorLog: log.StdLogger(errorlog.LevelError)}
+func (l *Log) StdLogger(level int) *log.Logger {
+	std := log.New(nil, "", 0)
+	std.SetOutput(l.stdWriter(std, level))
+	return std
+}
+
+// RedirectStdLog : 標準ライブラリ log パッケージのグローバルな出力を、指定したログレベルで出力する
+func (l *Log) RedirectStdLog(level int) {
+	log.SetOutput(l.stdWriter(log.Default(), level))
+}
+
+// log パッケージの出力を、指定したログレベルで出力する logger.StdWriter を生成する
+func (l *Log) stdWriter(std *log.Logger, level int) *logger.StdWriter {
+	return &logger.StdWriter{Std: std, Output: func(file, funcname string, line int, s string) error {
+		l.OutputForPC(level, file, funcname, line, "%s", s)
+		return nil
+	}}
+}
@@ -0,0 +1,141 @@
+package errorlog
+
+import (
+	"fmt"
+	"path"
+	"runtime"
+	"strings"
+)
+
+// VRule 構造体は、VModule の1つの指定を取り扱う構造体
+type VRule struct {
+	Pattern string // パッケージ名、ファイル名(拡張子を除く)に一致させるパターン。"/" を含む場合は、パッケージのパス、ファイルのパスの末尾に一致させる
+	Level   int    // パターンに一致した呼び出し元のログレベル
+}
+
+// ParseVModule : "db*=7,http=4" 形式の文字列を解析する。ログレベルには "debug" 等のログレベル名も指定できる。
+// パターンは path.Match の形式で指定する
+func ParseVModule(s string) ([]VRule, error) {
+	var rules []VRule
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		idx := strings.LastIndex(v, "=")
+		pattern := ""
+		if idx != -1 {
+			pattern = strings.TrimSuffix(strings.TrimSpace(v[:idx]), ".go")
+		}
+		if pattern == "" {
+			return nil, fmt.Errorf("\"%s\" is not pattern=level", v)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("\"%s\" is invalid pattern", pattern)
+		}
+		level, err := ParseLevel(v[idx+1:])
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, VRule{pattern, level})
+	}
+	return rules, nil
+}
+
+// 呼び出し元ごとの、VModule のログレベルと、ソースコードの情報
+type vsite struct {
+	level    int // 一致した VModule のログレベル。一致しない場合は -1
+	filename string
+	funcname string
+	linenum  int
+}
+
+// VModule を解析し、呼び出し元ごとのキャッシュを破棄する。l.mu をロックした状態で呼び出すこと
+func (l *Log) setVModule(vmodule string) error {
+	rules, err := ParseVModule(vmodule)
+	if err != nil {
+		return err
+	}
+	l.VModule = vmodule
+	l.vrules = rules
+	l.vsites = nil
+	return nil
+}
+
+// 実行中の呼び出し元のログレベルと、ソースファイル名、関数名、行番号を取得する。
+// source と同じ階層を参照するため、message から呼び出すこと。結果は呼び出し元ごとにキャッシュする
+func (l *Log) site() vsite {
+	var pcs [1]uintptr
+	if runtime.Callers(l.Depth+1, pcs[:]) == 0 {
+		return vsite{-1, "???", "???", 0}
+	}
+	return l.siteForPC(pcs[0])
+}
+
+// pc の呼び出し元のログレベルと、ソースコードの情報を取得する。l.mu をロックした状態で呼び出すこと
+func (l *Log) siteForPC(pc uintptr) vsite {
+	if s, ok := l.vsites[pc]; ok {
+		return s
+	}
+	frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	s := vsite{
+		level:    l.vlevel(frame.Function, frame.File),
+		filename: frame.File[strings.LastIndex(frame.File, "/")+1:],
+		funcname: frame.Function[strings.LastIndex(frame.Function, ".")+1:],
+		linenum:  frame.Line,
+	}
+	if l.vsites == nil {
+		l.vsites = map[uintptr]vsite{}
+	}
+	l.vsites[pc] = s
+	return s
+}
+
+// 関数名、ソースファイルのパスから、出力するログレベルの上限を取得する。VModule に一致しない場合は Level とする
+func (l *Log) levelFor(function, file string) int {
+	if level := l.vlevel(function, file); level != -1 {
+		return level
+	}
+	return l.GetLevel()
+}
+
+// 関数名、ソースファイルのパスから、最初に一致した VModule のログレベルを取得する。一致しない場合は -1 を返却する。
+// function は "github.com/ochipin/app/db.(*Conn).Query" 形式とし、空文字列の場合はファイル名のみで判定する
+func (l *Log) vlevel(function, file string) int {
+	// パッケージのパスを取得する ex) github.com/ochipin/app/db.(*Conn).Query ---> github.com/ochipin/app/db
+	pkg := function
+	slash := strings.LastIndex(pkg, "/")
+	if dot := strings.Index(pkg[slash+1:], "."); dot != -1 {
+		pkg = pkg[:slash+1+dot]
+	}
+	file = strings.TrimSuffix(file, ".go")
+	for _, r := range l.vrules {
+		if matchTail(r.Pattern, file) || (pkg != "" && matchTail(r.Pattern, pkg)) {
+			return r.Level
+		}
+	}
+	return -1
+}
+
+// パスの末尾の要素が pattern に一致するか判定する。pattern に "/" を含む場合は、その数だけ末尾の要素を使用する
+// ex) matchTail("app/db*", "github.com/ochipin/app/dbutil") ---> true
+func matchTail(pattern, name string) bool {
+	n := strings.Count(pattern, "/") + 1
+	idx := len(name)
+	for ; n > 0 && idx >= 0; n-- {
+		idx = strings.LastIndex(name[:idx], "/")
+	}
+	ok, _ := path.Match(pattern, name[idx+1:])
+	return ok
+}
+
+// VModule を含めた、最も詳細なログレベルを取得する
+func (l *Log) maxLevel() int {
+	max := l.GetLevel()
+	for _, r := range l.vrules {
+		if r.Level > max {
+			max = r.Level
+		}
+	}
+	return max
+}
@@ -0,0 +1,300 @@
+package logger
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// FollowOption 構造体は、Follow の読み込み開始位置と、ログファイルを確認する間隔を取り扱う構造体
+type FollowOption struct {
+	Offset   int64                          // ログファイルの読み込み開始位置(byte)。負数の場合は、ファイルの末尾から読み込む
+	Since    time.Time                      // 指定した場合、この日時以降に更新されたアーカイブから読み込む。Offset は無視される
+	Parse    func(string) (time.Time, bool) // ログの日時を取得する関数。指定した場合、Since より前のログは読み飛ばす
+	Interval time.Duration                  // ログファイルを確認する間隔。未指定の場合は500ミリ秒
+}
+
+// Line 構造体は、Follow で読み込んだ1行を取り扱う構造体
+type Line struct {
+	Path   string // 読み込んだファイル
+	Offset int64  // ファイル内での行の開始位置(byte)
+	Text   string // 改行を除いた行の内容
+}
+
+// OpenArchive : アーカイブを読み込む。gzip 圧縮(.gz)、暗号化(.enc)されたアーカイブは、展開、復号して読み込む。
+// 暗号化されていないアーカイブの場合、keys は nil でよい
+func OpenArchive(path string, keys KeyProvider) (io.ReadCloser, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		zr, err := gzip.NewReader(fp)
+		if err != nil {
+			fp.Close()
+			return nil, err
+		}
+		return readCloser{zr, fp}, nil
+	case strings.HasSuffix(path, encryptExt):
+		if keys == nil {
+			fp.Close()
+			return nil, fmt.Errorf("logger: %s: encryption key provider is not specified", path)
+		}
+		return readCloser{NewDecryptReader(fp, keys), fp}, nil
+	}
+	return fp, nil
+}
+
+// 展開、復号した内容を読み込み、Close で元のファイルを閉じる
+type readCloser struct {
+	io.Reader
+	fp *os.File
+}
+
+func (r readCloser) Close() error {
+	return r.fp.Close()
+}
+
+// Follow : tail -F と同様に、ログファイルへ追記された行を読み込み、チャネルへ送信する。
+// ログローテーション(copy-truncate)によるファイルの切り詰めと、ファイルの置き換え(rename)を検出して読み込みを継続する。
+// 切り詰められる直前に追記された行は、アーカイブから読み込む。ctx がキャンセルされると、チャネルを閉じて終了する
+func (l *Log) Follow(ctx context.Context, opt FollowOption) (<-chan Line, error) {
+	l.mu.Lock()
+	path := l.Path
+	l.mu.Unlock()
+	if path == "" {
+		return nil, fmt.Errorf("logger: log file path is not specified")
+	}
+	if opt.Interval <= 0 {
+		opt.Interval = 500 * time.Millisecond
+	}
+
+	ch := make(chan Line, 64)
+	f := &follower{l: l, ctx: ctx, ch: ch, opt: opt, path: path, passing: true}
+	go func() {
+		defer close(ch)
+		defer f.close()
+		f.run()
+	}()
+	return ch, nil
+}
+
+// ログファイルを追跡する
+type follower struct {
+	l       *Log
+	ctx     context.Context
+	ch      chan<- Line
+	opt     FollowOption
+	path    string
+	fp      *os.File    // 読み込み中のログファイル
+	info    os.FileInfo // 読み込み中のログファイルの情報。置き換えの検出に使用する
+	pos     int64       // 読み込み済みのバイト数
+	offset  int64       // 送信済みの行のバイト数
+	partial []byte      // 改行までを読み込めていない行
+	last    string      // 最後に送信した行
+	passing bool        // Since 以降のログか否か。日時を取得できない行は、直前の行に従う
+}
+
+func (f *follower) run() {
+	// 1. Since が指定されている場合は、アーカイブから読み込む
+	if !f.opt.Since.IsZero() {
+		for _, path := range f.l.Archives() {
+			if info, err := os.Stat(path); err != nil || info.ModTime().Before(f.opt.Since) {
+				continue
+			}
+			if !f.readArchive(path) {
+				return
+			}
+		}
+	}
+
+	tick := time.NewTicker(f.opt.Interval)
+	defer tick.Stop()
+	for {
+		// 2. ログファイルを開き、追記された行を読み込む
+		if f.fp == nil {
+			f.open()
+		}
+		if f.fp != nil && !f.read() {
+			return
+		}
+		select {
+		case <-f.ctx.Done():
+			return
+		case <-tick.C:
+		}
+		if f.fp != nil && !f.rotated() {
+			return
+		}
+	}
+}
+
+// ログファイルを開き、読み込み開始位置を決定する
+func (f *follower) open() {
+	fp, err := os.Open(f.path)
+	if err != nil {
+		return
+	}
+	info, err := fp.Stat()
+	if err != nil {
+		fp.Close()
+		return
+	}
+	start := f.opt.Offset
+	if f.info != nil || !f.opt.Since.IsZero() || start > info.Size() {
+		// ファイルが置き換えられた場合、Since が指定された場合は、先頭から読み込む
+		start = 0
+	} else if start < 0 {
+		start = info.Size()
+	}
+	f.fp, f.info, f.pos, f.offset, f.partial = fp, info, start, start, nil
+}
+
+func (f *follower) close() {
+	if f.fp != nil {
+		f.fp.Close()
+		f.fp = nil
+	}
+}
+
+// ログファイルに追記された行を読み込み、送信する。ctx がキャンセルされた場合は false を返却する
+func (f *follower) read() bool {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := f.fp.ReadAt(buf, f.pos)
+		f.pos += int64(n)
+		f.partial = append(f.partial, buf[:n]...)
+		for {
+			i := bytes.IndexByte(f.partial, '\n')
+			if i == -1 {
+				break
+			}
+			text := string(f.partial[:i])
+			if !f.send(Line{Path: f.path, Offset: f.offset, Text: text}) {
+				return false
+			}
+			f.last = text
+			f.offset += int64(i + 1)
+			f.partial = f.partial[i+1:]
+		}
+		if n == 0 || err != nil {
+			return true
+		}
+	}
+}
+
+// ログローテーションを検出し、読み込みを継続する。ctx がキャンセルされた場合は false を返却する
+func (f *follower) rotated() bool {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		// 置き換え中でファイルが存在しない場合は、次回確認する
+		return true
+	}
+	// 1. ファイルが置き換えられた場合は、元のファイルを最後まで読み込み、新しいファイルを先頭から読み込む
+	if !os.SameFile(f.info, info) {
+		if !f.read() {
+			return false
+		}
+		f.close()
+		return true
+	}
+	// 2. ファイルが切り詰められた場合は、読み込めなかった行をアーカイブから読み込み、先頭から読み込む
+	if info.Size() < f.pos {
+		if !f.recover() {
+			return false
+		}
+		f.pos, f.offset, f.partial = 0, 0, nil
+	}
+	return true
+}
+
+// 切り詰められる前に読み込めなかった行を、直近に更新されたアーカイブから読み込む。
+// 最後に送信した行をアーカイブから探し、その次の行から読み込む。見つからない場合は読み込まない
+func (f *follower) recover() bool {
+	archives := f.l.Archives()
+	if len(archives) == 0 || f.last == "" {
+		return true
+	}
+	path := archives[len(archives)-1]
+	r, err := OpenArchive(path, f.keys())
+	if err != nil {
+		return true
+	}
+	buf, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return true
+	}
+	needle := []byte(f.last + "\n")
+	i := bytes.LastIndex(buf, needle)
+	if i == -1 || (i != 0 && buf[i-1] != '\n') {
+		return true
+	}
+	return f.sendAll(path, int64(i+len(needle)), buf[i+len(needle):])
+}
+
+// アーカイブを読み込み、全ての行を送信する
+func (f *follower) readArchive(path string) bool {
+	r, err := OpenArchive(path, f.keys())
+	if err != nil {
+		f.l.alert("logger: " + err.Error())
+		return true
+	}
+	defer r.Close()
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		f.l.alert("logger: " + err.Error())
+	}
+	return f.sendAll(path, 0, buf)
+}
+
+// 読み込んだ内容を1行ずつ送信する
+func (f *follower) sendAll(path string, offset int64, buf []byte) bool {
+	for len(buf) != 0 {
+		i := bytes.IndexByte(buf, '\n')
+		if i == -1 {
+			i = len(buf)
+		}
+		if !f.send(Line{Path: path, Offset: offset, Text: string(buf[:i])}) {
+			return false
+		}
+		if i == len(buf) {
+			break
+		}
+		offset += int64(i + 1)
+		buf = buf[i+1:]
+	}
+	return true
+}
+
+// 1行を送信する。Since より前のログは送信しない。ctx がキャンセルされた場合は false を返却する
+func (f *follower) send(line Line) bool {
+	if !f.opt.Since.IsZero() && f.opt.Parse != nil {
+		if t, ok := f.opt.Parse(line.Text); ok {
+			f.passing = !t.Before(f.opt.Since)
+		}
+		if !f.passing {
+			return f.ctx.Err() == nil
+		}
+	}
+	select {
+	case f.ch <- line:
+		return true
+	case <-f.ctx.Done():
+		return false
+	}
+}
+
+// 暗号化されたアーカイブの復号に使用する KeyProvider を取得する
+func (f *follower) keys() KeyProvider {
+	f.l.mu.Lock()
+	defer f.l.mu.Unlock()
+	return f.l.Encrypt
+}
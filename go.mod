@@ -1 +1,3 @@
 module github.com/ochipin/logger
+
+go 1.21
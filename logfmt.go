@@ -0,0 +1,61 @@
+package logger
+
+import (
+	"strconv"
+	"unicode/utf8"
+)
+
+// AppendLogfmt : b の後ろへ、logfmt 形式の key=value を追記する。b が空でない場合は、空白で区切る。
+// キーに使用できない文字は "_" に置き換え、値は LogfmtValue と同様にクオートする
+// ex) time=2018-03-21T21:22:02+09:00 level=error msg="a.out not found"
+func AppendLogfmt(b []byte, key, value string) []byte {
+	if len(b) != 0 {
+		b = append(b, ' ')
+	}
+	if key == "" {
+		key = "_"
+	}
+	for i := 0; i < len(key); i++ {
+		if c := key[i]; c <= ' ' || c == '=' || c == '"' || c == 0x7f {
+			b = append(b, '_')
+		} else {
+			b = append(b, c)
+		}
+	}
+	b = append(b, '=')
+	if needsQuote(value) {
+		return strconv.AppendQuote(b, value)
+	}
+	return append(b, value...)
+}
+
+// LogfmtValue : logfmt の値として出力できるよう、空の値、空白、"="、"""、制御文字を含む値をクオートする
+func LogfmtValue(s string) string {
+	if needsQuote(s) {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// 値をクオートする必要があるか判定する
+func needsQuote(s string) bool {
+	if s == "" {
+		return true
+	}
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			if c <= ' ' || c == '=' || c == '"' || c == '\\' || c == 0x7f {
+				return true
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 || !strconv.IsPrint(r) {
+			return true
+		}
+		i += size
+	}
+	return false
+}
@@ -1,9 +1,9 @@
 package logger
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"log/syslog"
 	"os"
 	"path/filepath"
@@ -16,19 +16,90 @@ import (
 
 // Log 構造体は、ログ情報を取り扱う構造体
 type Log struct {
-	Path      string     // ログ保存パス
-	Lotate    string     // ログローテーションファイル名
-	Timing    string     // ログローテーションタイミング
-	Newline   bool       // ログ保存時に、改行を含めるか否か
-	Tabspace  bool       // ログ保存時に、タブを空白に置き換えるか
-	Trim      bool       // ログ保存時に、Trimする
-	Perm      int        // ログファイル作成時のパーミッション
-	Overwrite bool       // ログローテーション時に、既にあるファイルに対して上書きする
-	mu        sync.Mutex // 同時書き込み制御を行うMutex
-	out       *os.File   // 標準出力/標準エラー出力先
-	lotate    bool       // ログローテーションするか否か
-	hour      int        // ログローテーションする時刻
-	minute    int        // ログローテーションする時刻
+	Path          string            // ログ保存パス
+	Lotate        string            // ログローテーションファイル名
+	Timing        string            // ログローテーションタイミング
+	MaxLines      int               // ログファイルの行数がこの値に達した場合、ログローテーションする
+	Interval      time.Duration     // 前回のログローテーションからこの時間が経過した場合、ログローテーションする
+	Newline       bool              // ログ保存時に、改行を含めるか否か
+	Tabspace      bool              // ログ保存時に、タブを空白に置き換えるか
+	Trim          bool              // ログ保存時に、Trimする
+	Prefix        string            // ログの先頭へ付与する文字列。%t(日時), %h(ホスト名), %p(プロセスID), %s(連番), %n(Name) を使用できる
+	TimeLayout    string            // Prefix の %t に使用する日時の書式。未指定の場合は "2006-01-02 15:04:05"
+	TimePrecision int               // Prefix の %t に付与する、秒の小数点以下の桁数
+	Name          string            // Prefix の %n に使用するロガー名
+	Perm          int               // ログファイル作成時のパーミッション
+	DirPerm       int               // ディレクトリ作成時のパーミッション。未指定の場合は 0755
+	Owner         string            // ログファイル、ディレクトリの所有者。ユーザ名、またはユーザIDで指定する
+	Group         string            // ログファイル、ディレクトリのグループ。グループ名、またはグループIDで指定する
+	Overwrite     bool              // ログローテーション時に、既にあるファイルに対して上書きする
+	DiskSoft      uint64            // 空き容量(byte)がこの値を下回った場合、アーカイブの圧縮と削除を実施する
+	DiskHard      uint64            // 空き容量(byte)がこの値を下回った場合、ログファイルへの書き込みを停止する
+	DiskCheck     time.Duration     // 空き容量を確認する間隔。未指定の場合は10秒
+	DiskKeep      int               // DiskSoft によりアーカイブを削除する場合も、新しい順に残すアーカイブの数。未指定の場合は1
+	FallbackPath  string            // ログファイルへ書き込めない場合に、代わりに書き込むログファイル
+	RetryCount    int               // ログファイルへの書き込みに失敗した場合に、再試行する回数
+	RetryWait     time.Duration     // 再試行までの待ち時間
+	SpoolSize     int               // ログファイルへ書き込めなかったログを、メモリ上に保持する最大行数
+	OnError       func(error)       // ログファイルへの書き込みに失敗した場合に呼び出される関数
+	SyncPolicy    SyncPolicy        // ログファイルを fsync するタイミング。ゼロ値の場合は fsync しない
+	HashKey       []byte            // 指定した場合、HMAC-SHA256 のハッシュチェインを各行へ付与する
+	HashSidecar   bool              // ハッシュチェインを各行ではなく、サイドカーファイル(<ログファイル>.chain)へ書き込む
+	Encrypt       KeyProvider       // 指定した場合、ローテーション後のアーカイブを AES-256-GCM で暗号化する
+	ManifestPath  string            // 指定した場合、ログローテーションしたアーカイブの一覧を JSON 形式で書き込む
+	Uploader      Uploader          // 指定した場合、ログローテーションしたアーカイブをアップロードする
+	UploadQueue   string            // アップロード待ちのアーカイブを記録するファイル。再起動後もアップロードを再開する
+	UploadDelete  bool              // アップロードに成功したアーカイブを削除する
+	UploadRetry   time.Duration     // アップロードに失敗した場合に、再試行するまでの待ち時間。未指定の場合は1分
+	Dedup         bool              // 直前と同じログを出力せず、"message repeated N times" として集計する
+	DedupNumbers  bool              // 重複判定時に、数値の違いを無視する
+	DedupFlush    time.Duration     // 同じログが続いた場合に、集計行を出力する間隔。未指定の場合は10秒
+	RateLimit     float64           // 1秒あたりに出力するログの行数の上限。0 の場合は制限しない
+	RateBurst     int               // RateLimit を超えて、一度に出力できるログの行数
+	RateReport    time.Duration     // 出力を制限したログの行数を出力する間隔。未指定の場合は10秒
+	mu            sync.Mutex        // 同時書き込み制御を行うMutex
+	out           *os.File          // 標準出力/標準エラー出力先
+	lotate        bool              // ログローテーションするか否か
+	hour          int               // ログローテーションする時刻
+	minute        int               // ログローテーションする時刻
+	lines         int               // ログファイルの行数
+	linesLoaded   bool              // ログファイルの行数を読み込んだか否か
+	rotated       time.Time         // 前回ログローテーションした日時
+	segStart      bool              // 次に書き込む行が、ログファイルの先頭の行であるか否か
+	segFirst      time.Time         // ログファイルへ最初の行を書き込んだ日時
+	segLast       time.Time         // ログファイルへ最後の行を書き込んだ日時
+	keeping       bool              // Keeping によりログローテーションが要求されているか否か
+	running       bool              // ログローテーションを実施する goroutine が起動しているか否か
+	schedule      int               // ログローテーション時刻が変更されるたびに加算する世代番号
+	guarding      bool              // 空き容量を監視する goroutine が起動しているか否か
+	diskFull      bool              // 空き容量不足により、ログファイルへの書き込みを停止しているか否か
+	failing       bool              // ログファイルへ書き込めない状態が続いているか否か
+	retrying      bool              // 書き込みを再試行する goroutine が起動しているか否か
+	spool         []string          // ログファイルへ書き込めなかったログ
+	errs          []error           // OnError へ通知する書き込みエラー
+	stats         stats             // ログ出力の統計情報
+	chainLoaded   bool              // 直前のハッシュ値をログファイルから読み込んだか否か
+	chainPrev     []byte            // 直前の行のハッシュ値
+	uid           int               // Owner から求めたユーザID
+	gid           int               // Group から求めたグループID
+	drifts        map[string]string // 通知済みの、パーミッション、所有者が設定と異なるファイル
+	dedupRun      bool              // 重複判定の対象となる、直前のログがあるか否か
+	dedupLast     string            // 直前のログの重複判定キー
+	dedupCount    int               // 出力せずに集計した、直前と同じログの行数
+	dedupLine     string            // 出力せずに集計した、最後のログ
+	dedupTimer    *time.Timer       // 集計行を出力するタイマー
+	limiter       RateLimiter       // RateLimit による出力の制限
+	suppressed    uint64            // RateLimit により出力しなかった、未報告のログの行数
+	rateTimer     *time.Timer       // 出力を制限したログの行数を出力するタイマー
+	seq           uint64            // Prefix の %s に使用する連番
+	hostname      string            // Prefix の %h に使用するホスト名
+	unsynced      map[string]bool   // fsync していないファイル
+	unsyncedLines int               // fsync していない行数
+	syncTimer     *time.Timer       // SyncPolicy.Interval により fsync するタイマー
+	uploads       []string          // アップロード待ちのアーカイブ
+	queueLoaded   bool              // UploadQueue を読み込んだか否か
+	shipping      bool              // アップロードを実施する goroutine が起動しているか否か
+	reupload      bool              // アップロード中のアーカイブへ追記されたか否か
 }
 
 // Logger : ログ管理インタフェース
@@ -41,36 +112,149 @@ type Logger interface {
 
 // Initializer : ログ管理構造体にセットされたパラメータが適切かチェックし、パラメータを初期化する
 func (l *Log) Initializer(out *os.File) error {
+	lotate, hour, minute, err := l.timing()
+	if err != nil {
+		return err
+	}
+	l.lotate, l.hour, l.minute = lotate, hour, minute
+	l.rotated = time.Now()
+	l.segStart = isEmpty(l.Path)
+	// パーミッションを検証する
+	if l.Perm == 0 {
+		l.Perm = 0644
+	}
+	if l.DirPerm == 0 {
+		l.DirPerm = 0755
+	}
+	// 所有者、グループを検証する
+	uid, gid, err := LookupOwner(l.Owner, l.Group)
+	if err != nil {
+		return err
+	}
+	l.uid, l.gid = uid, gid
+	l.out = out
+	// 前回の起動時にアップロードできなかったアーカイブがあれば、アップロードを再開する
+	l.ship()
+
+	return nil
+}
+
+// ログローテーションの設定を検証し、ローテーションの有無と時刻を返却する
+func (l *Log) timing() (bool, int, int, error) {
 	// ログローテーションが有効か否かをチェックする
-	if !(l.Lotate == "" || l.Path == "" || l.Timing == "") {
-		l.lotate = true
+	if l.Lotate == "" || l.Path == "" || (l.Timing == "" && l.MaxLines <= 0 && l.Interval <= 0) {
+		return false, -1, -1, nil
+	}
+	// Timing を指定していない場合は、時刻によるログローテーションを実施しない
+	hour, minute := -1, -1
+	if l.Timing != "" {
 		// 時:分指定が正しいかチェックする
 		if !regexp.MustCompile(`^\d\d:\d\d$`).MatchString(l.Timing) {
-			return fmt.Errorf("logger: log lotation time is invalid")
+			return false, 0, 0, fmt.Errorf("logger: log lotation time is invalid")
 		}
 		// 時:分指定が正しい場合は、時, 分を数字に変換する
 		times := strings.Split(l.Timing, ":")
-		hour, _ := strconv.Atoi(times[0])
-		minute, _ := strconv.Atoi(times[1])
+		hour, _ = strconv.Atoi(times[0])
+		minute, _ = strconv.Atoi(times[1])
 		// 時(0-23), 分(0-59) の範囲の時刻であれば、正とみなし、その逆は負とみなす
-		if hour >= 0 && hour <= 23 && minute >= 0 && minute <= 59 {
-			l.hour = hour
-			l.minute = minute
-		} else {
-			return fmt.Errorf("logger: log lotation time is invalid")
-		}
-		// ローテーション後のパス命名が正しいかチェックする
-		_, filename := filepath.Split(l.Lotate)
-		if filename == "" {
-			return fmt.Errorf("logger: log lotate filename is invalid")
+		if !(hour >= 0 && hour <= 23 && minute >= 0 && minute <= 59) {
+			return false, 0, 0, fmt.Errorf("logger: log lotation time is invalid")
 		}
 	}
-	// パーミッションを検証する
-	if l.Perm == 0 {
-		l.Perm = 0644
+	// ローテーション後のパス命名が正しいかチェックする
+	_, filename := filepath.Split(l.Lotate)
+	if filename == "" {
+		return false, 0, 0, fmt.Errorf("logger: log lotate filename is invalid")
 	}
-	l.out = out
+	return true, hour, minute, nil
+}
 
+// Apply : 動作中のログ管理構造体へ、src にセットされたパラメータを反映する。
+// 反映はログ出力と排他的に行われるため、出力途中のログが反映途中の設定を参照することはない。
+// ログローテーション時刻が変更された場合は、Keeping のスケジュールを組み直す
+func (l *Log) Apply(src *Log) error {
+	lotate, hour, minute, err := src.timing()
+	if err != nil {
+		return err
+	}
+	perm := src.Perm
+	if perm == 0 {
+		perm = 0644
+	}
+	dirperm := src.DirPerm
+	if dirperm == 0 {
+		dirperm = 0755
+	}
+	uid, gid, err := LookupOwner(src.Owner, src.Group)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.lotate != lotate || l.hour != hour || l.minute != minute {
+		l.schedule++
+	}
+	// ログファイルが変更された場合は、行数を読み込み直す
+	if l.Path != src.Path {
+		l.linesLoaded = false
+		l.segStart, l.segFirst, l.segLast = isEmpty(src.Path), time.Time{}, time.Time{}
+	}
+	l.Path = src.Path
+	l.Lotate = src.Lotate
+	l.Timing = src.Timing
+	l.MaxLines = src.MaxLines
+	l.Interval = src.Interval
+	l.Newline = src.Newline
+	l.Tabspace = src.Tabspace
+	l.Trim = src.Trim
+	l.Prefix = src.Prefix
+	l.TimeLayout = src.TimeLayout
+	l.TimePrecision = src.TimePrecision
+	l.Name = src.Name
+	l.Perm = perm
+	l.DirPerm = dirperm
+	l.Owner = src.Owner
+	l.Group = src.Group
+	l.uid, l.gid = uid, gid
+	l.Overwrite = src.Overwrite
+	l.DiskSoft = src.DiskSoft
+	l.DiskHard = src.DiskHard
+	l.DiskCheck = src.DiskCheck
+	l.DiskKeep = src.DiskKeep
+	l.FallbackPath = src.FallbackPath
+	l.RetryCount = src.RetryCount
+	l.RetryWait = src.RetryWait
+	l.SpoolSize = src.SpoolSize
+	l.OnError = src.OnError
+	l.SyncPolicy = src.SyncPolicy
+	// ハッシュチェインの設定が変更された場合は、直前のハッシュ値を読み込み直す
+	if l.HashSidecar != src.HashSidecar {
+		l.chainLoaded = false
+	}
+	l.HashKey = src.HashKey
+	l.HashSidecar = src.HashSidecar
+	l.Encrypt = src.Encrypt
+	l.ManifestPath = src.ManifestPath
+	// アップロード待ちのアーカイブを記録するファイルが変更された場合は、読み込み直す
+	if l.UploadQueue != src.UploadQueue {
+		l.uploads, l.queueLoaded = nil, false
+	}
+	l.Uploader = src.Uploader
+	l.UploadQueue = src.UploadQueue
+	l.UploadDelete = src.UploadDelete
+	l.UploadRetry = src.UploadRetry
+	l.Dedup = src.Dedup
+	l.DedupNumbers = src.DedupNumbers
+	l.DedupFlush = src.DedupFlush
+	l.RateLimit = src.RateLimit
+	l.RateBurst = src.RateBurst
+	l.RateReport = src.RateReport
+	l.lotate, l.hour, l.minute = lotate, hour, minute
+	// Keeping 済みで、ログローテーション、空き容量の監視が新たに有効となった場合は goroutine を起動する
+	l.keep()
+	l.guard()
+	l.ship()
 	return nil
 }
 
@@ -84,21 +268,29 @@ func (l *Log) MakeLog(out *os.File) (Logger, error) {
 
 // Print : ログを出力する
 func (l *Log) Print(v ...interface{}) {
-	if err := l.output(fmt.Sprint(v...)); err != nil {
+	if err := l.output("", fmt.Sprint(v...)); err != nil {
 		l.alert("logger: " + err.Error())
 	}
 }
 
 // Printf : ログを出力する
 func (l *Log) Printf(format string, v ...interface{}) {
-	if err := l.output(fmt.Sprintf(format, v...)); err != nil {
+	if err := l.output("", fmt.Sprintf(format, v...)); err != nil {
+		l.alert("logger: " + err.Error())
+	}
+}
+
+// PrintKey : ログを出力する。Dedup の重複判定には、出力する文字列の代わりに key を使用する。
+// 日時等、行ごとに異なる値を含むログを出力する場合に使用する
+func (l *Log) PrintKey(key string, v ...interface{}) {
+	if err := l.output(key, fmt.Sprint(v...)); err != nil {
 		l.alert("logger: " + err.Error())
 	}
 }
 
 // Println : ログを出力する
 func (l *Log) Println(v ...interface{}) {
-	if err := l.output(fmt.Sprint(v...)); err != nil {
+	if err := l.output("", fmt.Sprint(v...)); err != nil {
 		l.alert("logger: " + err.Error())
 	}
 }
@@ -109,23 +301,36 @@ func (l *Log) Write(b []byte) (int, error) {
 	if l := len(b); l != 0 && b[l-1] == '\n' {
 		out = b[:l-1]
 	}
-	if err := l.output(string(out)); err != nil {
+	if err := l.output("", string(out)); err != nil {
 		l.alert("logger: " + err.Error())
 		return 0, err
 	}
 	return len(b), nil
 }
 
+// シスログへの接続。書き込みエラーのたびに接続し直さないよう、一度接続したものを使い回す
+var syslogWriter struct {
+	sync.Mutex
+	w *syslog.Writer
+}
+
 // シスログへ出力する
 func (l *Log) alert(message string) {
+	syslogWriter.Lock()
+	defer syslogWriter.Unlock()
 	// シスログに出力する設定を実施
-	logger, err := syslog.New(syslog.LOG_NOTICE|syslog.LOG_USER, "go-logger")
-	if err != nil {
-		return
+	if syslogWriter.w == nil {
+		w, err := syslog.New(syslog.LOG_NOTICE|syslog.LOG_USER, "go-logger")
+		if err != nil {
+			return
+		}
+		syslogWriter.w = w
+	}
+	// シスログへ出力する。出力に失敗した場合は、次回接続し直す
+	if err := syslogWriter.w.Notice(message); err != nil {
+		syslogWriter.w.Close()
+		syslogWriter.w = nil
 	}
-	// シスログへ出力する
-	log.SetOutput(logger)
-	log.Println(message)
 }
 
 // タブを半角空白へ置き換える
@@ -144,11 +349,28 @@ func (l *Log) trim(str string) string {
 	return strings.Trim(str, " ")
 }
 
-// ログにメッセージを出力する
-func (l *Log) output(s string) error {
+// ログにメッセージを出力する。key は Dedup の重複判定に使用し、空文字列の場合は s を使用する
+func (l *Log) output(key, s string) error {
 	l.mu.Lock()
-	defer l.mu.Unlock()
+	var err error
+	if l.limit() {
+		err = l.dedup(key, s)
+	}
+	// 書き込みエラーの通知は、通知先からのログ出力で停止しないよう、ロックを解放してから行う
+	hook, errs := l.OnError, l.errs
+	l.errs = nil
+	l.mu.Unlock()
+
+	if hook != nil {
+		for _, e := range errs {
+			hook(e)
+		}
+	}
+	return err
+}
 
+// ログにメッセージを出力する。l.mu をロックした状態で呼び出すこと
+func (l *Log) emit(s string) error {
 	// タブ ---> 空白置き換え
 	if l.Tabspace {
 		s = l.tabToBlank(s)
@@ -161,9 +383,14 @@ func (l *Log) output(s string) error {
 	if l.Trim {
 		s = l.trim(s)
 	}
+	// 先頭へ日時等を付与する
+	if l.Prefix != "" {
+		s = l.prefix(time.Now()) + s
+	}
 	// 標準出力/標準エラー出力のどちらかが設定されている場合、出力する
 	if l.out != nil {
 		fmt.Fprint(l.out, s+"\n")
+		l.stats.count(sinkConsole, s)
 	}
 	// ログファイル保存パスが未設定の場合、ファイルにはログ情報を保存しない
 	if l.Path == "" {
@@ -171,52 +398,136 @@ func (l *Log) output(s string) error {
 	}
 
 	// ログ情報をファイルへ書き込む
-	return l.savefile(s)
+	err := l.store(s)
+	// 行数が MaxLines に達した場合は、ログローテーションする
+	if l.lotate && l.MaxLines > 0 && l.lines >= l.MaxLines {
+		l.replace(time.Now())
+	}
+	return err
 }
 
 // ログ情報をファイルへ書き込む
 func (l *Log) savefile(s string) error {
+	start := time.Now()
+	var err error
+	if len(l.HashKey) != 0 {
+		err = l.writeChain(l.Path, s)
+	} else {
+		err = l.writefile(l.Path, s)
+	}
+	l.stats.writeTime += time.Since(start)
+	if err == nil {
+		l.stats.count(sinkFile, s)
+		l.countLines()
+		// マニフェストへ記録する、最初と最後の行を書き込んだ日時
+		if l.segStart {
+			l.segStart, l.segFirst = false, start
+		}
+		l.segLast = start
+	}
+	return err
+}
+
+// ログファイルの行数を加算する。初回はログファイルに書き込まれている行数を読み込む
+func (l *Log) countLines() {
+	if l.MaxLines <= 0 {
+		return
+	}
+	if !l.linesLoaded {
+		l.linesLoaded = true
+		l.lines = 0
+		if buf, err := ioutil.ReadFile(l.Path); err == nil {
+			l.lines = bytes.Count(buf, []byte("\n"))
+		}
+		return
+	}
+	l.lines++
+}
+
+// ログ情報を指定されたファイルへ書き込む
+func (l *Log) writefile(path, s string) error {
 	// ログ保存先のパスから、ディレクトリ名のみ抜き出し、ディレクトリを作成する
-	dir, _ := filepath.Split(l.Path)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	dir, _ := filepath.Split(path)
+	if err := l.mkdir(dir); err != nil {
 		return err
 	}
 	// ファイルオープンをする
-	fp, err := os.OpenFile(l.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, os.FileMode(l.Perm))
+	fp, err := l.openfile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND)
 	if err != nil {
 		return err
 	}
-	defer fp.Close()
 	// ログファイルへ書き込む
-	fmt.Fprint(fp, s+"\n")
-	return nil
+	_, err = fmt.Fprint(fp, s+"\n")
+	if err == nil && l.SyncPolicy.Always {
+		err = datasync(fp)
+	}
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		l.dirty(path)
+	}
+	return err
 }
 
-// Keeping : ログファイルをローテーションする
+// Keeping : ログファイルをローテーションする。DiskSoft, DiskHard が指定されている場合は、空き容量の監視も開始する
 func (l *Log) Keeping() {
-	// ログローテーションを実施いない場合は何もせず関数を抜ける
-	if l.lotate == false {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.keeping = true
+	// ログローテーションを実施しない場合は、goroutine を起動しない
+	l.keep()
+	l.guard()
+}
+
+// ログローテーションを実施する goroutine を起動する。l.mu をロックした状態で呼び出すこと
+func (l *Log) keep() {
+	if !l.keeping || !l.lotate || l.running {
 		return
 	}
+	l.running = true
 
 	go func() {
 		tick := time.NewTicker(time.Duration(1) * time.Second)
+		defer tick.Stop()
 		ok := false
+		schedule := -1
 		for {
 			select {
 			// 1秒置きにログローテーションを実施する
 			case <-tick.C:
+				l.mu.Lock()
+				// ログローテーションが無効化された場合は、goroutine を終了する
+				if !l.lotate {
+					l.running = false
+					l.mu.Unlock()
+					return
+				}
+				// ログローテーション時刻が変更された場合は、処理フラグを初期化する
+				if schedule != l.schedule {
+					schedule = l.schedule
+					ok = false
+				}
+				hour, minute, interval, rotated := l.hour, l.minute, l.Interval, l.rotated
+				l.mu.Unlock()
+
 				now := time.Now()
-				if l.hour == now.Hour() && l.minute == now.Minute() {
+				// 前回のログローテーションから Interval が経過した場合は、ログローテーションを実施する
+				due := interval > 0 && now.Sub(rotated) >= interval
+				if hour == now.Hour() && minute == now.Minute() {
 					// 指定時刻になったら、1度だけログローテーションを実施する
 					if ok == false {
-						l.logReplace(now)
+						due = true
 						ok = true
 					}
 				} else {
 					// 指定時刻以外の場合、処理フラグをfalseにする
 					ok = false
 				}
+				// Interval と指定時刻が重なった場合も、ログローテーションは1度だけ実施する
+				if due {
+					l.logReplace(now)
+				}
 			}
 		}
 	}()
@@ -230,31 +541,105 @@ func (l *Log) getLotateName(now time.Time) string {
 		"%m", fmt.Sprintf("%02d", int(now.Month())),
 		"%d", fmt.Sprintf("%02d", now.Day()),
 		"%H", fmt.Sprintf("%02d", now.Hour()),
+		"%M", fmt.Sprintf("%02d", now.Minute()),
 		"%w", week)
 	return rep.Replace(l.Lotate)
 }
 
+// ログローテーションするファイル名を返却する。%N を含む場合は、既存のアーカイブと重複しない、1から始まる連番に置き換える
+func (l *Log) lotateName(now time.Time) string {
+	name := l.getLotateName(now)
+	if !strings.Contains(name, "%N") {
+		return name
+	}
+	for n := 1; ; n++ {
+		path := strings.Replace(name, "%N", strconv.Itoa(n), -1)
+		if !exists(path) && !exists(path+".gz") && !exists(path+encryptExt) {
+			return path
+		}
+	}
+}
+
+// ファイルが存在しないか、空であるか否かを返却する
+func isEmpty(path string) bool {
+	info, err := os.Stat(path)
+	return err != nil || info.Size() == 0
+}
+
+// ファイルが存在するか否かを返却する
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
 // ログファイルを置き換える
 func (l *Log) logReplace(now time.Time) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	l.replace(now)
+}
+
+// ログファイルを置き換え、Encrypt を指定した場合はアーカイブを暗号化する。l.mu をロックした状態で呼び出すこと。
+// 暗号化を終えるまでに次のログローテーションが同じアーカイブへ追記しないよう、暗号化もロックした状態で行う
+func (l *Log) replace(now time.Time) {
+	start := time.Now()
+	lotatepath, err := l.rotate(now)
+	if err != nil {
+		l.stats.errors++
+		l.alert("logger: " + err.Error())
+		return
+	}
+	l.stats.rotations++
+	l.stats.rotationTime += time.Since(start)
+	l.rotated = now
+	l.linesLoaded = false
+	if l.ManifestPath != "" {
+		if err := l.record(lotatepath, now); err != nil {
+			l.stats.errors++
+			l.alert("logger: " + err.Error())
+		}
+	}
+	l.segStart, l.segFirst, l.segLast = true, time.Time{}, time.Time{}
+	// 暗号化する場合は、暗号化後のアーカイブをアップロードする
+	if l.Encrypt == nil {
+		l.enqueue(lotatepath)
+		return
+	}
+	err = encryptFile(lotatepath, l.Encrypt, os.FileMode(l.Perm), l.Overwrite)
+	if err == nil {
+		uid, gid := l.owner()
+		err = chown(lotatepath+encryptExt, uid, gid)
+	}
+	if err == nil {
+		err = l.renameSegment(lotatepath, lotatepath+encryptExt)
+	}
+	if err != nil {
+		l.stats.errors++
+		l.alert("logger: " + err.Error())
+		return
+	}
+	l.enqueue(lotatepath + encryptExt)
+}
 
+// ログファイルの内容をローテーション後のファイルへ移動する。l.mu をロックした状態で呼び出すこと
+func (l *Log) rotate(now time.Time) (string, error) {
 	// ログローテーションするファイル名を変数へ格納
 	// ex) log/%Y%m/app-%Y%m%d.log ---> log/201803/app-20180322.log
-	lotatepath := l.getLotateName(now)
+	lotatepath := l.lotateName(now)
 	// ex) log/201803/, app-20180322.log
 	dirname, filename := filepath.Split(lotatepath)
 	// ログローテーションするファイル名が不正の場合、関数を抜ける
 	if filename == "" {
-		l.alert("logger: log lotate filename is invalid")
-		return
+		return "", fmt.Errorf("log lotate filename is invalid")
 	}
 	// ディレクトリが存在しない場合、作成する
-	if dirname != "" {
-		if err := os.MkdirAll(dirname, 0755); err != nil {
-			l.alert("logger: " + err.Error())
-			return
-		}
+	if err := l.mkdir(dirname); err != nil {
+		return "", err
+	}
+
+	// ハッシュチェインを付与する場合は、ログファイルを移動する前に直前のハッシュ値を読み込む
+	if len(l.HashKey) != 0 {
+		l.loadChain()
 	}
 
 	// 1. ローテーションするファイルをオープン
@@ -263,14 +648,13 @@ func (l *Log) logReplace(now time.Time) {
 	if l.Overwrite {
 		// 上書きの場合は、ローテーションするファイルを作り直し
 		os.Remove(lotatepath)
-		fp, err = os.OpenFile(lotatepath, os.O_WRONLY|os.O_CREATE, os.FileMode(l.Perm))
+		fp, err = l.openfile(lotatepath, os.O_WRONLY|os.O_CREATE)
 	} else {
 		// 追加書き込みの場合は、既存のローテーションファイルを読み込み
-		fp, err = os.OpenFile(lotatepath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, os.FileMode(l.Perm))
+		fp, err = l.openfile(lotatepath, os.O_WRONLY|os.O_CREATE|os.O_APPEND)
 	}
 	if err != nil {
-		l.alert("logger: " + err.Error())
-		return
+		return "", err
 	}
 	defer fp.Close()
 	// 2. ログファイルを読み込む
@@ -278,8 +662,55 @@ func (l *Log) logReplace(now time.Time) {
 	if err != nil {
 		buf = []byte{}
 	}
-	// 3. ログファイルの内容をローテーション後のログファイルへ書き込む
-	fmt.Fprint(fp, string(buf))
+	// 3. ログファイルの内容をローテーション後のログファイルへ書き込み、ログファイルを切り詰める前に、アーカイブとディレクトリを fsync する
+	if _, err := fp.Write(buf); err != nil {
+		return "", err
+	}
+	if err := fp.Sync(); err != nil {
+		return "", err
+	}
+	if err := syncDir(dirname); err != nil {
+		return "", err
+	}
 	// 4. ログファイルの中身を0バイトにする
-	ioutil.WriteFile(l.Path, []byte(""), os.FileMode(l.Perm))
+	if err := ioutil.WriteFile(l.Path, []byte(""), os.FileMode(l.Perm)); err != nil {
+		return "", err
+	}
+	if err := syncFile(l.Path); err != nil {
+		return "", err
+	}
+	if len(l.HashKey) == 0 {
+		return lotatepath, nil
+	}
+	// 5. サイドカーファイルもローテーションし、チェックポイントとジェネシス行を書き込む
+	if l.HashSidecar {
+		buf, err := ioutil.ReadFile(l.Path + chainExt)
+		if err != nil {
+			buf = []byte{}
+		}
+		if l.Overwrite {
+			os.Remove(lotatepath + chainExt)
+		}
+		fp, err := l.openfile(lotatepath+chainExt, os.O_WRONLY|os.O_CREATE|os.O_APPEND)
+		if err != nil {
+			return "", err
+		}
+		_, err = fp.Write(buf)
+		if err == nil {
+			err = fp.Sync()
+		}
+		if cerr := fp.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return "", err
+		}
+		if err := ioutil.WriteFile(l.Path+chainExt, []byte(""), os.FileMode(l.Perm)); err != nil {
+			return "", err
+		}
+	}
+	if err := l.checkpoint(lotatepath, now); err != nil {
+		return "", err
+	}
+	return lotatepath, syncFile(lotatepath)
 }
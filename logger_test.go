@@ -1,8 +1,19 @@
 package logger
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"fmt"
+	"io/ioutil"
+	stdlog "log"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
 	"time"
 )
@@ -257,3 +268,896 @@ func TestLoggerMkdirLotateError(t *testing.T) {
 	log.Keeping()
 	time.Sleep(2 * time.Second)
 }
+
+// 動作中のログ管理構造体へのパラメータ反映
+func TestLoggerApply(t *testing.T) {
+	log := Log{
+		Path: "test/loggertemp.log",
+	}
+	l, err := log.MakeLog(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Keeping()
+
+	// 不正なローテーション時刻は反映されない
+	if err := log.Apply(&Log{Path: "test/loggertemp.log", Lotate: "test/apply.log", Timing: "25:00"}); err == nil {
+		t.Fatal("invalid timing must be error")
+	}
+	if log.Timing != "" || log.running {
+		t.Fatal("invalid timing is applied")
+	}
+
+	// ローテーションが有効になった場合は、Keeping のスケジュールが組み直される
+	if err := log.Apply(&Log{Path: "test/loggertemp.log", Lotate: "test/apply.log", Timing: "23:59", Trim: true}); err != nil {
+		t.Fatal(err)
+	}
+	log.mu.Lock()
+	if !log.running || log.hour != 23 || log.minute != 59 || log.Perm != 0644 || !log.Trim {
+		t.Fatal("applied parameters are invalid")
+	}
+	log.mu.Unlock()
+	l.Print("  Hello World  ")
+
+	// ローテーションが無効になった場合は、goroutine が終了する
+	if err := log.Apply(&Log{Path: "test/loggertemp.log"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1500 * time.Millisecond)
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	if log.running {
+		t.Fatal("keeping goroutine is still running")
+	}
+}
+
+// 空き容量不足時のアーカイブ圧縮、削除と、ファイル書き込みの停止/再開
+func TestLoggerDiskGuard(t *testing.T) {
+	os.RemoveAll("test/disk")
+	os.MkdirAll("test/disk/201801", 0755)
+	for i, name := range []string{"test/disk/201801/app-20180101.log", "test/disk/201801/app-20180102.log"} {
+		ioutil.WriteFile(name, []byte("Hello World\n"), 0644)
+		old := time.Now().Add(time.Duration(i-10) * time.Hour)
+		os.Chtimes(name, old, old)
+	}
+
+	var free uint64 = 500
+	diskFree = func(string) (uint64, error) { return free, nil }
+	defer func() { diskFree = freeSpace }()
+
+	log := Log{
+		Path:     "test/disk/app.log",
+		Lotate:   "test/disk/%Y%m/app-%Y%m%d.log",
+		DiskSoft: 1000,
+		DiskHard: 100,
+		// 削除したアーカイブは、アップロード待ちからも取り除かれる
+		UploadQueue: "test/disk/queue",
+	}
+	ioutil.WriteFile("test/disk/queue", []byte("test/disk/201801/app-20180101.log\n"), 0644)
+	l, err := log.MakeLog(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// DiskSoft を下回った場合、アーカイブを圧縮し、古いアーカイブから削除する。最新のアーカイブは残す
+	log.checkDisk()
+	if _, err := os.Stat("test/disk/201801/app-20180101.log"); err == nil {
+		t.Fatal("archive is not removed")
+	}
+	if archives := log.archives(); len(archives) != 1 || archives[0] != "test/disk/201801/app-20180102.log.gz" {
+		t.Fatal(archives)
+	}
+	if buf, err := ioutil.ReadFile("test/disk/queue"); err != nil || len(buf) != 0 {
+		t.Fatal(string(buf), err)
+	}
+
+	// DiskHard を下回った場合、ファイルへの書き込みを停止する
+	free = 50
+	log.checkDisk()
+	l.Print("Hello World")
+	if _, err := os.Stat("test/disk/app.log"); err == nil {
+		t.Fatal("log file must not be written")
+	}
+
+	// 空き容量が回復した場合、書き込みを再開する
+	free = 5000
+	log.checkDisk()
+	l.Print("Hello World")
+	if buf, err := ioutil.ReadFile("test/disk/app.log"); err != nil || string(buf) != "Hello World\n" {
+		t.Fatal(string(buf), err)
+	}
+}
+
+// アーカイブの圧縮
+func TestLoggerCompress(t *testing.T) {
+	os.RemoveAll("test/compress")
+	os.MkdirAll("test/compress", 0755)
+	ioutil.WriteFile("test/compress/app.log.1", []byte("Hello\n"), 0640)
+	log := Log{Path: "test/compress/app.log", Lotate: "test/compress/app.log.%d"}
+	gz, err := log.compress("test/compress/app.log.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile("test/compress/app.log.1", []byte("World\n"), 0640)
+	if _, err := log.compress("test/compress/app.log.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	fp, err := os.Open(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	zr, err := gzip.NewReader(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf, err := ioutil.ReadAll(zr); err != nil || string(buf) != "Hello\nWorld\n" {
+		t.Fatal(string(buf), err)
+	}
+}
+
+// 書き込みエラー発生時のスプールと、書き込み再開時の再送
+func TestLoggerSpool(t *testing.T) {
+	os.RemoveAll("test/recover")
+	os.MkdirAll("test/recover", 0755)
+	// ディレクトリとなるべきパスにファイルを作成し、書き込めない状態にする
+	ioutil.WriteFile("test/recover/broken", []byte{}, 0644)
+
+	var errs []error
+	log := Log{
+		Path:       "test/recover/broken/app.log",
+		RetryCount: 2,
+		SpoolSize:  2,
+		OnError:    func(err error) { errs = append(errs, err) },
+	}
+	l, err := log.MakeLog(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Print("line 1")
+	l.Print("line 2")
+	l.Print("line 3")
+	if len(log.spool) != 2 || log.stats.dropped != 1 || len(errs) != 4 {
+		t.Fatal(log.spool, log.stats.dropped, errs)
+	}
+
+	// 書き込めるようになった場合、スプールしたログを順に書き込む
+	os.Remove("test/recover/broken")
+	l.Print("line 4")
+	if buf, _ := ioutil.ReadFile("test/recover/broken/app.log"); string(buf) != "line 2\nline 3\nline 4\n" {
+		t.Fatal(string(buf))
+	}
+	if err := log.Flush(); err != nil || log.failing {
+		t.Fatal(err)
+	}
+}
+
+// 書き込みの再試行は、ログ出力を待たせずに別の goroutine で行う
+func TestLoggerRetry(t *testing.T) {
+	os.RemoveAll("test/retry")
+	os.MkdirAll("test/retry", 0755)
+	ioutil.WriteFile("test/retry/broken", []byte{}, 0644)
+
+	log := Log{
+		Path:       "test/retry/broken/app.log",
+		RetryCount: 2,
+		RetryWait:  100 * time.Millisecond,
+	}
+	l, err := log.MakeLog(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := func() (bool, uint64) {
+		log.mu.Lock()
+		defer log.mu.Unlock()
+		return log.failing, log.stats.dropped
+	}
+	start := time.Now()
+	l.Print("line 1")
+	l.Print("line 2")
+	l.Print("line 3")
+	if d := time.Since(start); d >= 100*time.Millisecond {
+		t.Fatal(d)
+	}
+	// 代替パス、スプールを指定しない場合は、再試行しても書き込めなかったログを破棄する
+	time.Sleep(300 * time.Millisecond)
+	if failing, dropped := state(); !failing || dropped != 3 {
+		t.Fatal(failing, dropped)
+	}
+
+	// 書き込めるようになった場合は、書き込みを再開する
+	os.Remove("test/retry/broken")
+	l.Print("line 4")
+	if buf, _ := ioutil.ReadFile("test/retry/broken/app.log"); string(buf) != "line 4\n" {
+		t.Fatal(string(buf))
+	}
+	if failing, _ := state(); failing {
+		t.Fatal("log file is not writable")
+	}
+
+	// 再試行中に書き込めるようになった場合は、退避したログを書き込む
+	os.RemoveAll("test/retry/broken")
+	ioutil.WriteFile("test/retry/broken", []byte{}, 0644)
+	l.Print("line 5")
+	os.Remove("test/retry/broken")
+	time.Sleep(150 * time.Millisecond)
+	if buf, _ := ioutil.ReadFile("test/retry/broken/app.log"); string(buf) != "line 5\n" {
+		t.Fatal(string(buf))
+	}
+	if failing, dropped := state(); failing || dropped != 3 {
+		t.Fatal(failing, dropped)
+	}
+}
+
+// 書き込みエラー発生時の代替パスへの書き込み
+func TestLoggerFallback(t *testing.T) {
+	os.RemoveAll("test/fallback")
+	os.MkdirAll("test/fallback", 0755)
+	ioutil.WriteFile("test/fallback/broken", []byte{}, 0644)
+
+	log := Log{
+		Path:         "test/fallback/broken/app.log",
+		FallbackPath: "test/fallback/app.log",
+	}
+	l, err := log.MakeLog(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Print("line 1")
+	l.Printf("line %d", 2)
+	if buf, _ := ioutil.ReadFile("test/fallback/app.log"); string(buf) != "line 1\nline 2\n" {
+		t.Fatal(string(buf))
+	}
+}
+
+// ログ出力の統計情報
+func TestLoggerStats(t *testing.T) {
+	os.RemoveAll("test/stats")
+	log := Log{
+		Path:   "test/stats/app.log",
+		Lotate: "test/stats/app.%Y%m%d.log",
+		Timing: "00:00",
+	}
+	l, err := log.MakeLog(os.Stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Print("Hello World")
+	l.Printf("%s %d", "Hello World", 200)
+	log.logReplace(time.Now())
+
+	stats := log.Stats()
+	if stats.Lines["console"] != 2 || stats.Lines["file"] != 2 || stats.Bytes["file"] != 28 {
+		t.Fatal(stats)
+	}
+	if stats.Rotations != 1 || stats.Errors != 0 || stats.Dropped != 0 {
+		t.Fatal(stats)
+	}
+}
+
+// log パッケージの出力からのプレフィックス、日時等の除去
+func TestStripStd(t *testing.T) {
+	tests := []struct {
+		line   string
+		prefix string
+		flag   int
+		file   string
+		num    int
+		result string
+	}{
+		{"Hello World\n", "", 0, "", 0, "Hello World"},
+		{"app: 2009/01/23 01:23:23 Hello World\n", "app: ", stdlog.LstdFlags, "", 0, "Hello World"},
+		{"2009/01/23 01:23:23.123123 app: Hello World\n", "app: ", stdlog.LstdFlags | stdlog.Lmicroseconds | stdlog.Lmsgprefix, "", 0, "Hello World"},
+		{"01:23:23 main.go:23: Hello: World\n", "", stdlog.Ltime | stdlog.Lshortfile, "main.go", 23, "Hello: World"},
+		{"/a: b/main.go:23: Hello\n", "", stdlog.Llongfile, "/a: b/main.go", 23, "Hello"},
+	}
+	for _, test := range tests {
+		file, num, result := StripStd(test.line, test.prefix, test.flag)
+		if file != test.file || num != test.num || result != test.result {
+			t.Fatalf("%q: %q %d %q", test.line, file, num, result)
+		}
+	}
+}
+
+// log パッケージからログ管理構造体への出力
+func TestLoggerStdLogger(t *testing.T) {
+	os.RemoveAll("test/std")
+	log := Log{Path: "test/std/app.log"}
+	if _, err := log.MakeLog(nil); err != nil {
+		t.Fatal(err)
+	}
+	std := log.StdLogger()
+	std.SetPrefix("app: ")
+	std.SetFlags(stdlog.LstdFlags | stdlog.Lshortfile)
+	std.Println("Hello World")
+	std.Printf("%s %d", "Hello World", 200)
+
+	if buf, _ := ioutil.ReadFile("test/std/app.log"); string(buf) != "Hello World\nHello World 200\n" {
+		t.Fatal(string(buf))
+	}
+}
+
+// ハッシュチェインの付与と検証
+func TestLoggerChain(t *testing.T) {
+	for _, sidecar := range []bool{false, true} {
+		os.RemoveAll("test/chain")
+		key := []byte("secret")
+		log := Log{
+			Path:        "test/chain/app.log",
+			Lotate:      "test/chain/app.%Y%m%d.log",
+			Timing:      "00:00",
+			HashKey:     key,
+			HashSidecar: sidecar,
+		}
+		l, err := log.MakeLog(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.Print("Hello World")
+		l.Print("Hello\nWorld")
+		now := time.Now()
+		log.logReplace(now)
+		l.Print("Hello World 200")
+
+		// 再起動後も、直前のハッシュ値からチェインを継続する
+		restart := Log{Path: "test/chain/app.log", HashKey: key, HashSidecar: sidecar}
+		restart.MakeLog(nil)
+		restart.Print("Hello World 300")
+
+		archive := log.getLotateName(now)
+		for _, path := range []string{archive, "test/chain/app.log"} {
+			if err := VerifyChain(path, key); err != nil {
+				t.Fatal(sidecar, err)
+			}
+		}
+		if err := VerifyChain(archive, []byte("invalid")); err == nil {
+			t.Fatal(sidecar, "invalid key is verified")
+		}
+
+		// 改ざんされた行を検出する
+		buf, _ := ioutil.ReadFile("test/chain/app.log")
+		ioutil.WriteFile("test/chain/app.log", []byte(strings.Replace(string(buf), "World 200", "World 201", 1)), 0644)
+		err = VerifyChain("test/chain/app.log", key)
+		if e, ok := err.(*ChainError); !ok || e.Line != 2 {
+			t.Fatal(sidecar, err)
+		}
+	}
+}
+
+// ローテーション後のアーカイブの暗号化と復号
+func TestLoggerEncrypt(t *testing.T) {
+	os.RemoveAll("test/encrypt")
+	keys := &StaticKeys{Current: "k1", Keys: map[string][]byte{
+		"k1": bytes.Repeat([]byte{1}, 32),
+		"k2": bytes.Repeat([]byte{2}, 32),
+	}}
+	log := Log{
+		Path:    "test/encrypt/app.log",
+		Lotate:  "test/encrypt/app.%Y%m%d.log",
+		Timing:  "00:00",
+		Encrypt: keys,
+	}
+	l, err := log.MakeLog(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	archive := log.getLotateName(now)
+	l.Print("Hello World")
+	log.logReplace(now)
+	// 鍵を切り替えた後のアーカイブは、同じファイルへ別のストリームとして追記される
+	keys.Current = "k2"
+	l.Print(strings.Repeat("a", 100000))
+	log.logReplace(now)
+
+	if _, err := os.Stat(archive); !os.IsNotExist(err) {
+		t.Fatal("plaintext archive is not removed")
+	}
+	enc, err := ioutil.ReadFile(archive + encryptExt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(enc, []byte("Hello World")) {
+		t.Fatal("archive is not encrypted")
+	}
+	buf, err := ioutil.ReadAll(NewDecryptReader(bytes.NewReader(enc), keys))
+	if err != nil || string(buf) != "Hello World\n"+strings.Repeat("a", 100000)+"\n" {
+		t.Fatal(len(buf), err)
+	}
+
+	// 途切れたストリーム、鍵の無いストリームは復号できない
+	if _, err := ioutil.ReadAll(NewDecryptReader(bytes.NewReader(enc[:len(enc)-20]), keys)); err == nil {
+		t.Fatal("truncated stream is decrypted")
+	}
+	if _, err := ioutil.ReadAll(NewDecryptReader(bytes.NewReader(enc), &StaticKeys{Keys: map[string][]byte{"k1": keys.Keys["k1"]}})); err == nil {
+		t.Fatal("stream is decrypted without key")
+	}
+	// チャンクの長さが壊れたストリームは、読み込まずにエラーとする
+	broken := append([]byte{}, enc...)
+	binary.BigEndian.PutUint32(broken[len(encryptMagic)+1+2+8:], 0xffffffff)
+	if _, err := ioutil.ReadAll(NewDecryptReader(bytes.NewReader(broken), keys)); err == nil || err == ErrTruncated {
+		t.Fatal(err)
+	}
+}
+
+// 行数によるログローテーションで、同じアーカイブ名へ繰り返し暗号化する場合も、ログが欠けずに順に復号できる
+func TestLoggerEncryptMaxLines(t *testing.T) {
+	os.RemoveAll("test/encmax")
+	keys := &StaticKeys{Current: "k1", Keys: map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}}
+	log := Log{
+		Path:     "test/encmax/app.log",
+		Lotate:   "test/encmax/app.old.log",
+		MaxLines: 2,
+		Encrypt:  keys,
+	}
+	l, err := log.MakeLog(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ""
+	for i := 1; i <= 20; i++ {
+		l.Printf("line %d", i)
+		want += fmt.Sprintf("line %d\n", i)
+	}
+
+	if _, err := os.Stat("test/encmax/app.old.log"); !os.IsNotExist(err) {
+		t.Fatal("plaintext archive is not removed")
+	}
+	enc, err := ioutil.ReadFile("test/encmax/app.old.log" + encryptExt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadAll(NewDecryptReader(bytes.NewReader(enc), keys))
+	if err != nil || string(buf) != want {
+		t.Fatal(string(buf), err)
+	}
+}
+
+// ログローテーションを跨いだログファイルの追跡
+func TestLoggerFollow(t *testing.T) {
+	os.RemoveAll("test/follow")
+	log := Log{
+		Path:   "test/follow/app.log",
+		Lotate: "test/follow/app.%Y%m%d.log",
+		Timing: "00:00",
+	}
+	l, err := log.MakeLog(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Print("line1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := log.Follow(ctx, FollowOption{Interval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive := func(want string) {
+		t.Helper()
+		select {
+		case line := <-ch:
+			if line.Text != want {
+				t.Fatalf("%+v, want %s", line, want)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("%s is not received", want)
+		}
+	}
+	receive("line1")
+	l.Print("line2")
+	receive("line2")
+
+	// copy-truncate の直前に追記された行は、アーカイブから読み込む
+	l.Print("line3")
+	log.logReplace(time.Now())
+	receive("line3")
+	time.Sleep(50 * time.Millisecond)
+	l.Print("line4")
+	receive("line4")
+
+	// ファイルの置き換え
+	os.Rename("test/follow/app.log", "test/follow/app.old")
+	l.Print("line5")
+	receive("line5")
+
+	// Since を指定した場合は、アーカイブから読み込む
+	since, cancelSince := context.WithCancel(context.Background())
+	defer cancelSince()
+	ch, _ = log.Follow(since, FollowOption{Since: time.Now().Add(-time.Hour), Interval: 10 * time.Millisecond})
+	for _, want := range []string{"line1", "line2", "line3", "line5"} {
+		receive(want)
+	}
+
+	cancel()
+	cancelSince()
+	for range ch {
+	}
+}
+
+// ディレクトリのパーミッション、所有者の指定と、パーミッションの差異の通知
+func TestLoggerOwner(t *testing.T) {
+	os.RemoveAll("test/owner")
+	var errs []error
+	log := Log{
+		Path:    "test/owner/a/app.log",
+		Lotate:  "test/owner/b/app.%Y%m%d.log",
+		Timing:  "00:00",
+		Perm:    0640,
+		DirPerm: 0750,
+		Owner:   fmt.Sprint(os.Getuid()),
+		Group:   fmt.Sprint(os.Getgid()),
+		OnError: func(err error) { errs = append(errs, err) },
+		// マニフェストも、ログファイルと同じパーミッション、所有者で作成する
+		ManifestPath: "test/owner/c/manifest.json",
+	}
+	l, err := log.MakeLog(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Print("Hello World")
+	now := time.Now()
+	log.logReplace(now)
+
+	modes := map[string]os.FileMode{
+		"test/owner/a":               0750,
+		"test/owner/b":               0750,
+		"test/owner/a/app.log":       0640,
+		log.getLotateName(now):       0640,
+		"test/owner/c":               0750,
+		"test/owner/c/manifest.json": 0640,
+	}
+	for path, mode := range modes {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != mode {
+			t.Fatalf("%s: %v", path, info.Mode())
+		}
+		if uid, gid, ok := fileOwner(info); ok && (uid != os.Getuid() || gid != os.Getgid()) {
+			t.Fatalf("%s: %d:%d", path, uid, gid)
+		}
+	}
+
+	// パーミッションの差異は1度だけ通知する
+	os.Chmod("test/owner/a/app.log", 0666)
+	l.Print("Hello World")
+	l.Print("Hello World")
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "permission 0666 differs from 0640") {
+		t.Fatal(errs)
+	}
+
+	invalid := Log{Path: "test/owner/app.log", Owner: "no-such-user-for-logger-test"}
+	if _, err := invalid.MakeLog(nil); err == nil {
+		t.Fatal("invalid owner is accepted")
+	}
+}
+
+// 重複したログの集計
+func TestLoggerDedup(t *testing.T) {
+	os.RemoveAll("test/dedup")
+	log := Log{
+		Path:         "test/dedup/app.log",
+		Dedup:        true,
+		DedupNumbers: true,
+		DedupFlush:   50 * time.Millisecond,
+	}
+	l, err := log.MakeLog(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Print("connection 1 refused")
+	l.Print("connection 2 refused")
+	l.Print("connection 3 refused")
+	l.Print("Hello World")
+	l.Print("Hello World")
+	// タイマーにより集計行が出力された後は、同じログも出力する
+	time.Sleep(200 * time.Millisecond)
+	l.Print("Hello World")
+	l.Print("Hello World")
+	log.Flush()
+
+	buf, _ := ioutil.ReadFile("test/dedup/app.log")
+	// 集計したログが1行の場合は、集計行ではなくそのログを出力する
+	want := "connection 1 refused\nmessage repeated 2 times\nHello World\nHello World\nHello World\nHello World\n"
+	if string(buf) != want {
+		t.Fatal(string(buf))
+	}
+	if stats := log.Stats(); stats.Repeated != 4 {
+		t.Fatal(stats)
+	}
+}
+
+// ロガー全体の出力の制限
+func TestLoggerRateLimit(t *testing.T) {
+	os.RemoveAll("test/rate")
+	log := Log{
+		Path:       "test/rate/app.log",
+		RateLimit:  1,
+		RateBurst:  2,
+		RateReport: 50 * time.Millisecond,
+	}
+	l, err := log.MakeLog(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		l.Printf("Hello World %d", i)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	buf, _ := ioutil.ReadFile("test/rate/app.log")
+	if string(buf) != "Hello World 0\nHello World 1\nrate limit exceeded, suppressed 3 lines\n" {
+		t.Fatal(string(buf))
+	}
+	if stats := log.Stats(); stats.Suppressed != 3 {
+		t.Fatal(stats)
+	}
+}
+
+// トークンバケットの補充
+func TestRateLimiter(t *testing.T) {
+	r := RateLimiter{Rate: 2, Burst: 2}
+	now := time.Now()
+	if !r.Allow(now) || !r.Allow(now) || r.Allow(now) {
+		t.Fatal("burst is not limited")
+	}
+	if !r.Allow(now.Add(500*time.Millisecond)) || r.Allow(now.Add(500*time.Millisecond)) {
+		t.Fatal("token is not refilled")
+	}
+	if unlimited := (RateLimiter{}); !unlimited.Allow(now) {
+		t.Fatal("zero rate is limited")
+	}
+}
+
+// ログの先頭への日時、ホスト名等の付与
+func TestLoggerPrefix(t *testing.T) {
+	os.RemoveAll("test/prefix")
+	log := Log{
+		Path:          "test/prefix/app.log",
+		Prefix:        "%t %h[%p] %n #%s 100%% ",
+		TimeLayout:    "2006-01-02T15:04:05",
+		TimePrecision: 3,
+		Name:          "app",
+	}
+	l, err := log.MakeLog(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Print("Hello World")
+	l.Print("Hello World")
+
+	host, _ := os.Hostname()
+	buf, _ := ioutil.ReadFile("test/prefix/app.log")
+	lines := strings.Split(string(buf), "\n")
+	for i, line := range lines[:2] {
+		want := fmt.Sprintf(`^\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{3} %s\[%d\] app #%d 100%% Hello World$`, regexp.QuoteMeta(host), os.Getpid(), i+1)
+		if !regexp.MustCompile(want).MatchString(line) {
+			t.Fatal(line)
+		}
+	}
+}
+
+// 行数によるログローテーションと、%N による連番
+func TestLoggerMaxLines(t *testing.T) {
+	os.RemoveAll("test/maxlines")
+	os.MkdirAll("test/maxlines", 0755)
+	// 既存のログファイルの行数も数える
+	ioutil.WriteFile("test/maxlines/app.log", []byte("line 0\n"), 0644)
+	log := Log{
+		Path:     "test/maxlines/app.log",
+		Lotate:   "test/maxlines/%Y%m%d/app-%N.log",
+		MaxLines: 3,
+	}
+	l, err := log.MakeLog(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 7; i++ {
+		l.Printf("line %d", i)
+	}
+
+	dir := filepath.Dir(log.getLotateName(time.Now()))
+	for name, want := range map[string]string{
+		dir + "/app-1.log":      "line 0\nline 1\nline 2\n",
+		dir + "/app-2.log":      "line 3\nline 4\nline 5\n",
+		"test/maxlines/app.log": "line 6\nline 7\n",
+	} {
+		if buf, _ := ioutil.ReadFile(name); string(buf) != want {
+			t.Fatal(name, string(buf))
+		}
+	}
+	if archives := log.Archives(); len(archives) != 2 {
+		t.Fatal(archives)
+	}
+}
+
+// 経過時間によるログローテーション
+func TestLoggerInterval(t *testing.T) {
+	os.RemoveAll("test/interval")
+	log := Log{
+		Path:     "test/interval/app.log",
+		Lotate:   "test/interval/app-%N.log",
+		Interval: 500 * time.Millisecond,
+	}
+	l, err := log.MakeLog(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Keeping()
+	l.Print("Hello World")
+	time.Sleep(1500 * time.Millisecond)
+
+	if buf, _ := ioutil.ReadFile("test/interval/app-1.log"); string(buf) != "Hello World\n" {
+		t.Fatal(string(buf))
+	}
+	// Timing を指定していない場合は、時刻によるログローテーションを行わない
+	if log.hour != -1 || log.minute != -1 {
+		t.Fatal(log.hour, log.minute)
+	}
+}
+
+// Interval と Timing が同じ時刻に重なった場合も、ログローテーションは1度だけ実施する
+func TestLoggerIntervalTiming(t *testing.T) {
+	os.RemoveAll("test/intervaltiming")
+	timing := time.Now().Format("15:04")
+	log := Log{
+		Path:     "test/intervaltiming/app.log",
+		Lotate:   "test/intervaltiming/app-%N.log",
+		Interval: 500 * time.Millisecond,
+		Timing:   timing,
+	}
+	l, err := log.MakeLog(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Keeping()
+	l.Print("Hello World")
+	time.Sleep(1500 * time.Millisecond)
+	// 待機中に指定時刻を過ぎた場合は、重ならないため判定しない
+	if time.Now().Format("15:04") != timing {
+		return
+	}
+
+	if buf, _ := ioutil.ReadFile("test/intervaltiming/app-1.log"); string(buf) != "Hello World\n" {
+		t.Fatal(string(buf))
+	}
+	if _, err := os.Stat("test/intervaltiming/app-2.log"); !os.IsNotExist(err) {
+		t.Fatal("rotated twice in one tick")
+	}
+}
+
+// fsync するタイミング
+func TestLoggerSync(t *testing.T) {
+	for s, want := range map[string]SyncPolicy{
+		"":       {},
+		"none":   {},
+		"always": {Always: true},
+		"100":    {Lines: 100},
+		"1s":     {Interval: time.Second},
+	} {
+		p, err := ParseSyncPolicy(s)
+		if err != nil || p != want {
+			t.Fatal(s, p, err)
+		}
+		if s != "" && p.String() != s {
+			t.Fatal(s, p.String())
+		}
+	}
+	if _, err := ParseSyncPolicy("0"); err == nil {
+		t.Fatal("0 lines is accepted")
+	}
+
+	os.RemoveAll("test/sync")
+	log := Log{
+		Path:       "test/sync/app.log",
+		SyncPolicy: SyncPolicy{Lines: 3},
+	}
+	l, err := log.MakeLog(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Print("Hello World")
+	l.Print("Hello World")
+	if log.unsyncedLines != 2 || !log.unsynced["test/sync/app.log"] {
+		t.Fatal(log.unsyncedLines, log.unsynced)
+	}
+	l.Print("Hello World")
+	if log.unsyncedLines != 0 || log.unsynced != nil {
+		t.Fatal(log.unsyncedLines, log.unsynced)
+	}
+
+	// 間隔を指定した場合は、タイマーにより fsync する
+	log.Apply(&Log{Path: "test/sync/app.log", SyncPolicy: SyncPolicy{Interval: 50 * time.Millisecond}})
+	l.Print("Hello World")
+	time.Sleep(200 * time.Millisecond)
+	log.mu.Lock()
+	if log.unsyncedLines != 0 || log.syncTimer != nil {
+		t.Fatal(log.unsyncedLines)
+	}
+	log.mu.Unlock()
+
+	// 書き込みのたびに fdatasync する場合は、fsync を遅らせない
+	log.Apply(&Log{Path: "test/sync/app.log", SyncPolicy: SyncPolicy{Always: true}})
+	l.Print("Hello World")
+	if log.unsyncedLines != 0 {
+		t.Fatal(log.unsyncedLines)
+	}
+	if buf, _ := ioutil.ReadFile("test/sync/app.log"); strings.Count(string(buf), "\n") != 5 {
+		t.Fatal(string(buf))
+	}
+}
+
+// ログローテーションしたアーカイブのマニフェスト
+func TestLoggerManifest(t *testing.T) {
+	os.RemoveAll("test/manifest")
+	log := Log{
+		Path:         "test/manifest/app.log",
+		Lotate:       "test/manifest/app-%N.log",
+		MaxLines:     2,
+		ManifestPath: "test/manifest/manifest.json",
+	}
+	l, err := log.MakeLog(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for i := 1; i <= 5; i++ {
+		l.Printf("line %d", i)
+	}
+
+	m, err := log.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Segments) != 2 {
+		t.Fatal(m.Segments)
+	}
+	for i, seg := range m.Segments {
+		buf, _ := ioutil.ReadFile(seg.Path)
+		sum := sha256.Sum256(buf)
+		if seg.Path != fmt.Sprintf("test/manifest/app-%d.log", i+1) || seg.Lines != 2 || seg.Size != int64(len(buf)) ||
+			seg.SHA256 != hex.EncodeToString(sum[:]) || seg.Compression != "none" || seg.Encrypted {
+			t.Fatal(seg)
+		}
+		if seg.First.Before(start) || seg.Last.Before(seg.First) {
+			t.Fatal(seg.First, seg.Last)
+		}
+	}
+	if segs := m.Find(time.Now().Add(time.Hour), time.Time{}); len(segs) != 0 {
+		t.Fatal(segs)
+	}
+	if segs := m.Find(start, time.Now()); len(segs) != 2 {
+		t.Fatal(segs)
+	}
+
+	// 圧縮したアーカイブは、マニフェストへ反映される
+	if _, err := log.compress("test/manifest/app-1.log"); err != nil {
+		t.Fatal(err)
+	}
+	m, _ = log.Manifest()
+	if seg, ok := m.Lookup("test/manifest/app-1.log.gz"); !ok || seg.Compression != "gzip" || seg.Lines != 2 {
+		t.Fatal(m.Segments)
+	}
+	// アーカイブはログローテーションした順に並ぶ
+	os.Chtimes("test/manifest/app-1.log.gz", time.Now().Add(time.Hour), time.Now().Add(time.Hour))
+	if archives := log.Archives(); len(archives) != 2 || archives[0] != "test/manifest/app-1.log.gz" {
+		t.Fatal(archives)
+	}
+}
+
+func TestLogfmt(t *testing.T) {
+	b := AppendLogfmt(nil, "level", "error")
+	b = AppendLogfmt(b, "msg", "a.out not found")
+	b = AppendLogfmt(b, "user id", "")
+	b = AppendLogfmt(b, "q", `a=b "c"`+"\n")
+	b = AppendLogfmt(b, "path", "/日本語")
+	if want := `level=error msg="a.out not found" user_id="" q="a=b \"c\"\n" path=/日本語`; string(b) != want {
+		t.Fatal(string(b))
+	}
+	if LogfmtValue("404") != "404" || LogfmtValue("a b") != `"a b"` || LogfmtValue("\xff") != `"\xff"` {
+		t.Fatal("LogfmtValue")
+	}
+}
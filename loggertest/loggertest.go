@@ -0,0 +1,382 @@
+package loggertest
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ochipin/logger"
+	"github.com/ochipin/logger/accesslog"
+	"github.com/ochipin/logger/errorlog"
+)
+
+// NoLevel : ログレベルを持たないログ(logger, accesslog)の Entry.Level に格納される値
+const NoLevel = -1
+
+// 記録したログの種類
+const (
+	KindLogger    = "logger"    // logger.Logger へ出力されたログ
+	KindErrorlog  = "errorlog"  // errorlog.Logger へ出力されたログ
+	KindAccesslog = "accesslog" // accesslog.Logger へ出力されたログ
+)
+
+// Entry 構造体は、記録したログ1件を取り扱う構造体
+type Entry struct {
+	Kind    string            // ログの種類(logger/errorlog/accesslog)
+	Time    time.Time         // ログを出力した時刻
+	Level   int               // ログレベル。errorlog 以外は NoLevel
+	File    string            // ログ出力関数がコールされた場所(ソースコードファイル名)
+	Func    string            // ログ出力関数がコールされた場所(関数名)
+	Line    int               // ログ出力関数がコールされた場所(行番号)
+	Message string            // ログ出力関数に渡したメッセージ内容
+	Fields  map[string]string // メッセージ以外の情報。errorlog の場合は With, Errorw 等で付与したフィールド、accesslog の場合は status, method, path 等を格納する
+}
+
+// String : ログを1行の文字列に変換する
+func (e Entry) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s(%s:%d) ", e.Kind, e.File, e.Func, e.Line)
+	if name := errorlog.LevelName(e.Level); name != "" {
+		b.WriteString(name + ": ")
+	}
+	b.WriteString(e.Message)
+	var keys []string
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%q", k, e.Fields[k])
+	}
+	return b.String()
+}
+
+// Recorder 構造体は、ログをファイルへ書き込まずにメモリ上へ記録する構造体
+type Recorder struct {
+	Level   int        // errorlog で記録するログレベル。New では 7(debug) が設定される
+	mu      sync.Mutex // 同時記録を制御するMutex
+	entries []Entry    // 記録したログ
+}
+
+// New : Recorder を生成する。テストが失敗した場合は、記録したログを t.Log へ出力する
+func New(t testing.TB) *Recorder {
+	r := &Recorder{Level: errorlog.LevelDebug}
+	t.Cleanup(func() {
+		if !t.Failed() {
+			return
+		}
+		for _, e := range r.Entries() {
+			t.Log(e.String())
+		}
+	})
+	return r
+}
+
+// Entries : 記録したログを返却する
+func (r *Recorder) Entries() []Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Entry{}, r.entries...)
+}
+
+// Reset : 記録したログを破棄する
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = nil
+}
+
+// Find : ログレベルが level で、メッセージに substr を含むログを返却する。
+// level に NoLevel を指定した場合は、ログレベルを問わない
+func (r *Recorder) Find(level int, substr string) []Entry {
+	var result []Entry
+	for _, e := range r.Entries() {
+		if (level == NoLevel || e.Level == level) && strings.Contains(e.Message, substr) {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// AssertLogged : ログレベルが level で、メッセージに substr を含むログが記録されていなければ、テストを失敗させる
+func (r *Recorder) AssertLogged(t testing.TB, level int, substr string) {
+	t.Helper()
+	if len(r.Find(level, substr)) == 0 {
+		t.Errorf("loggertest: %s log containing %q is not logged", levelName(level), substr)
+	}
+}
+
+// AssertNotLogged : ログレベルが level で、メッセージに substr を含むログが記録されていれば、テストを失敗させる
+func (r *Recorder) AssertNotLogged(t testing.TB, level int, substr string) {
+	t.Helper()
+	if found := r.Find(level, substr); len(found) != 0 {
+		t.Errorf("loggertest: %s log containing %q is logged: %s", levelName(level), substr, found[0])
+	}
+}
+
+// AssertCount : 記録されたログの件数が n でなければ、テストを失敗させる
+func (r *Recorder) AssertCount(t testing.TB, n int) {
+	t.Helper()
+	if entries := r.Entries(); len(entries) != n {
+		t.Errorf("loggertest: %d logs are logged, want %d", len(entries), n)
+	}
+}
+
+// アサーションのメッセージに使用するログレベル名を取得する
+func levelName(level int) string {
+	if name := errorlog.LevelName(level); name != "" {
+		return name
+	}
+	return "any"
+}
+
+// ログを記録する。skip には record を呼び出した関数から見た、ログ出力関数の呼び出し元の階層を指定する
+func (r *Recorder) record(skip int, e Entry) {
+	e.Time = time.Now()
+	e.File, e.Func, e.Line = "???", "???", 0
+	if pc, filename, linenum, ok := runtime.Caller(skip + 1); ok {
+		funcname := runtime.FuncForPC(pc).Name()
+		e.Func = funcname[strings.LastIndex(funcname, ".")+1:]
+		e.File = filename[strings.LastIndex(filename, "/")+1:]
+		e.Line = linenum
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, e)
+}
+
+// Logger : 出力したログを Recorder へ記録する logger.Logger を返却する
+func (r *Recorder) Logger() logger.Logger {
+	return &baseLogger{r}
+}
+
+// Errorlog : 出力したログを Recorder へ記録する errorlog.Logger を返却する
+func (r *Recorder) Errorlog() errorlog.Logger {
+	return &errorLogger{r: r, depth: 3}
+}
+
+// Accesslog : 出力したログを Recorder へ記録する accesslog.Logger を返却する
+func (r *Recorder) Accesslog() accesslog.Logger {
+	return &accessLogger{r}
+}
+
+// logger.Logger の実装
+type baseLogger struct {
+	r *Recorder
+}
+
+func (l *baseLogger) output(message string) {
+	l.r.record(2, Entry{Kind: KindLogger, Level: NoLevel, Message: message})
+}
+
+func (l *baseLogger) Print(v ...interface{}) {
+	l.output(fmt.Sprint(v...))
+}
+
+func (l *baseLogger) Printf(format string, v ...interface{}) {
+	l.output(fmt.Sprintf(format, v...))
+}
+
+func (l *baseLogger) Println(v ...interface{}) {
+	l.output(fmt.Sprint(v...))
+}
+
+func (l *baseLogger) Write(b []byte) (int, error) {
+	l.output(strings.TrimSuffix(string(b), "\n"))
+	return len(b), nil
+}
+
+// errorlog.Logger の実装。Emerg はプログラムを終了させずに記録のみ行う
+type errorLogger struct {
+	r      *Recorder
+	depth  int
+	fields map[string]string // With で付与したフィールド
+}
+
+// ログレベルが記録対象であれば記録する。skip は output を呼び出した関数から見た呼び出し元の階層
+func (l *errorLogger) output(level, skip int, message string) {
+	l.outputw(level, skip+1, message, nil)
+}
+
+// kv のフィールドを付与して記録する。skip は outputw を呼び出した関数から見た呼び出し元の階層
+func (l *errorLogger) outputw(level, skip int, message string, kv []interface{}) {
+	l.r.mu.Lock()
+	enabled := level <= l.r.Level
+	l.r.mu.Unlock()
+	if enabled {
+		l.r.record(skip+1, Entry{Kind: KindErrorlog, Level: level, Message: message, Fields: withFields(l.fields, kv)})
+	}
+}
+
+// fields に、キーと値を交互に並べた kv を加えた map を返却する。キーが文字列でない場合は "!BADKEY" をキーとする
+func withFields(fields map[string]string, kv []interface{}) map[string]string {
+	if len(fields) == 0 && len(kv) == 0 {
+		return nil
+	}
+	result := map[string]string{}
+	for k, v := range fields {
+		result[k] = v
+	}
+	for i := 0; i < len(kv); i++ {
+		key, ok := kv[i].(string)
+		if !ok || i+1 == len(kv) {
+			result["!BADKEY"] = fmt.Sprint(kv[i])
+			continue
+		}
+		result[key] = fmt.Sprint(kv[i+1])
+		i++
+	}
+	return result
+}
+
+func (l *errorLogger) Emerg(v ...interface{}) {
+	l.output(0, 1, fmt.Sprint(v...))
+}
+
+func (l *errorLogger) Emergf(format string, v ...interface{}) {
+	l.output(0, 1, fmt.Sprintf(format, v...))
+}
+
+func (l *errorLogger) Emergw(msg string, kv ...interface{}) {
+	l.outputw(0, 1, msg, kv)
+}
+
+func (l *errorLogger) Alert(v ...interface{}) {
+	l.output(1, 1, fmt.Sprint(v...))
+}
+
+func (l *errorLogger) Alertf(format string, v ...interface{}) {
+	l.output(1, 1, fmt.Sprintf(format, v...))
+}
+
+func (l *errorLogger) Alertw(msg string, kv ...interface{}) {
+	l.outputw(1, 1, msg, kv)
+}
+
+func (l *errorLogger) Crit(v ...interface{}) {
+	l.output(2, 1, fmt.Sprint(v...))
+}
+
+func (l *errorLogger) Critf(format string, v ...interface{}) {
+	l.output(2, 1, fmt.Sprintf(format, v...))
+}
+
+func (l *errorLogger) Critw(msg string, kv ...interface{}) {
+	l.outputw(2, 1, msg, kv)
+}
+
+func (l *errorLogger) Error(v ...interface{}) {
+	l.output(3, 1, fmt.Sprint(v...))
+}
+
+func (l *errorLogger) Errorf(format string, v ...interface{}) {
+	l.output(3, 1, fmt.Sprintf(format, v...))
+}
+
+func (l *errorLogger) Errorw(msg string, kv ...interface{}) {
+	l.outputw(3, 1, msg, kv)
+}
+
+func (l *errorLogger) Warn(v ...interface{}) {
+	l.output(4, 1, fmt.Sprint(v...))
+}
+
+func (l *errorLogger) Warnf(format string, v ...interface{}) {
+	l.output(4, 1, fmt.Sprintf(format, v...))
+}
+
+func (l *errorLogger) Warnw(msg string, kv ...interface{}) {
+	l.outputw(4, 1, msg, kv)
+}
+
+func (l *errorLogger) Notice(v ...interface{}) {
+	l.output(5, 1, fmt.Sprint(v...))
+}
+
+func (l *errorLogger) Noticef(format string, v ...interface{}) {
+	l.output(5, 1, fmt.Sprintf(format, v...))
+}
+
+func (l *errorLogger) Noticew(msg string, kv ...interface{}) {
+	l.outputw(5, 1, msg, kv)
+}
+
+func (l *errorLogger) Info(v ...interface{}) {
+	l.output(6, 1, fmt.Sprint(v...))
+}
+
+func (l *errorLogger) Infof(format string, v ...interface{}) {
+	l.output(6, 1, fmt.Sprintf(format, v...))
+}
+
+func (l *errorLogger) Infow(msg string, kv ...interface{}) {
+	l.outputw(6, 1, msg, kv)
+}
+
+func (l *errorLogger) Debug(v ...interface{}) {
+	l.output(7, 1, fmt.Sprint(v...))
+}
+
+func (l *errorLogger) Debugf(format string, v ...interface{}) {
+	l.output(7, 1, fmt.Sprintf(format, v...))
+}
+
+func (l *errorLogger) Debugw(msg string, kv ...interface{}) {
+	l.outputw(7, 1, msg, kv)
+}
+
+// With : フィールドを付与して記録する errorlog.Logger を返却する
+func (l *errorLogger) With(kv ...interface{}) errorlog.Logger {
+	return &errorLogger{r: l.r, depth: l.depth, fields: withFields(l.fields, kv)}
+}
+
+// Output : depth は errorlog.Log と同様に、3 で Output の呼び出し元を指す
+func (l *errorLogger) Output(level, depth int, format string, v ...interface{}) {
+	l.output(level, depth-2, fmt.Sprintf(format, v...))
+}
+
+// OutputForPC : 呼び出し元の情報には、引数で指定されたファイル名、関数名、行番号を記録する
+func (l *errorLogger) OutputForPC(level int, filename, funcname string, line int, format string, v ...interface{}) {
+	l.r.mu.Lock()
+	defer l.r.mu.Unlock()
+	if level <= l.r.Level {
+		l.r.entries = append(l.r.entries, Entry{
+			Kind: KindErrorlog, Time: time.Now(), Level: level,
+			File: filename, Func: funcname, Line: line, Message: fmt.Sprintf(format, v...), Fields: withFields(l.fields, nil),
+		})
+	}
+}
+
+func (l *errorLogger) GetDepth() int {
+	return l.depth
+}
+
+func (l *errorLogger) SetDepth(depth int) {
+	l.depth = depth
+}
+
+// accesslog.Logger の実装
+type accessLogger struct {
+	r *Recorder
+}
+
+func (l *accessLogger) Print(status int, start time.Time, r *http.Request) {
+	addr, _, _ := net.SplitHostPort(r.RemoteAddr)
+	fields := map[string]string{
+		"status":      fmt.Sprint(status),
+		"method":      r.Method,
+		"path":        r.URL.Path,
+		"query":       r.URL.RawQuery,
+		"remote_addr": addr,
+		"elapsed":     time.Since(start).String(),
+	}
+	message := fmt.Sprintf("%s %s %d", r.Method, r.URL.RequestURI(), status)
+	l.r.record(1, Entry{Kind: KindAccesslog, Level: NoLevel, Message: message, Fields: fields})
+}
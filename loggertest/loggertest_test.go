@@ -0,0 +1,109 @@
+package loggertest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// アサーションの失敗を記録する testing.TB
+type fakeTB struct {
+	testing.TB
+	errors []string
+}
+
+func (t *fakeTB) Helper() {}
+func (t *fakeTB) Errorf(format string, v ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, v...))
+}
+
+func TestRecorder(t *testing.T) {
+	r := New(t)
+	r.Level = 6
+
+	l := r.Errorlog()
+	l.Errorf("%s not found", "a.out")
+	l.Info("Hello World")
+	l.Debug("Hello World")
+	l.Output(4, 3, "warning %d", 200)
+	l.OutputForPC(3, "main.go", "main", 11, "Hello World")
+
+	r.AssertLogged(t, 3, "a.out not found")
+	r.AssertLogged(t, 4, "warning 200")
+	r.AssertNotLogged(t, 7, "Hello World")
+	r.AssertCount(t, 4)
+
+	// 呼び出し元の情報が記録されているか
+	e := r.Find(3, "a.out")[0]
+	if e.File != "loggertest_test.go" || e.Func != "TestRecorder" || e.Line != 27 {
+		t.Fatal(e)
+	}
+	if e := r.Find(4, "warning")[0]; e.File != "loggertest_test.go" || e.Line != 30 {
+		t.Fatal(e)
+	}
+	if e := r.Find(3, "Hello World")[0]; e.File != "main.go" || e.Line != 11 {
+		t.Fatal(e)
+	}
+
+	// アサーションに失敗した場合、テストが失敗するか
+	fake := &fakeTB{}
+	r.AssertLogged(fake, 3, "not logged message")
+	r.AssertNotLogged(fake, NoLevel, "Hello World")
+	r.AssertCount(fake, 0)
+	if len(fake.errors) != 3 {
+		t.Fatal(fake.errors)
+	}
+
+	r.Reset()
+	r.AssertCount(t, 0)
+}
+
+func TestRecorderLogger(t *testing.T) {
+	r := New(t)
+	l := r.Logger()
+	l.Print("Hello", "World")
+	l.Printf("%s %d", "Hello World", 200)
+	fmt.Fprintln(l, "written")
+
+	r.AssertLogged(t, NoLevel, "HelloWorld")
+	r.AssertLogged(t, NoLevel, "Hello World 200")
+	if e := r.Find(NoLevel, "written")[0]; e.Message != "written" || e.Kind != KindLogger {
+		t.Fatal(e)
+	}
+}
+
+func TestRecorderAccesslog(t *testing.T) {
+	r := New(t)
+	l := r.Accesslog()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		l.Print(404, time.Now(), req)
+	}))
+	defer server.Close()
+	if _, err := http.Get(server.URL + "/index.html?query=200"); err != nil {
+		t.Fatal(err)
+	}
+
+	r.AssertLogged(t, NoLevel, "GET /index.html?query=200 404")
+	e := r.Entries()[0]
+	if e.Fields["status"] != "404" || e.Fields["path"] != "/index.html" || e.Fields["query"] != "query=200" {
+		t.Fatal(e)
+	}
+}
+
+func TestRecorderWith(t *testing.T) {
+	r := New(t)
+	l := r.Errorlog().With("request_id", "abc")
+	l.Errorw("not found", "path", "/index.html")
+	l.Info("Hello World")
+
+	e := r.Find(3, "not found")[0]
+	if e.Fields["request_id"] != "abc" || e.Fields["path"] != "/index.html" || e.File != "loggertest_test.go" || e.Func != "TestRecorderWith" {
+		t.Fatal(e)
+	}
+	if e := r.Find(6, "Hello World")[0]; len(e.Fields) != 1 || e.Fields["request_id"] != "abc" {
+		t.Fatal(e)
+	}
+}
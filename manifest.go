@@ -0,0 +1,271 @@
+package logger
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Segment 構造体は、ログローテーションしたアーカイブ1つの情報を取り扱う構造体
+type Segment struct {
+	Path        string    `json:"path"`        // アーカイブのパス
+	First       time.Time `json:"first"`       // 最初の行を書き込んだ日時。ロガーの起動前から書き込まれていた行を含む場合はゼロ値
+	Last        time.Time `json:"last"`        // 最後の行を書き込んだ日時
+	Lines       int       `json:"lines"`       // 行数
+	Size        int64     `json:"size"`        // アーカイブのバイト数
+	SHA256      string    `json:"sha256"`      // アーカイブの SHA-256
+	Compression string    `json:"compression"` // 圧縮形式。"none" または "gzip"
+	Encrypted   bool      `json:"encrypted"`   // 暗号化されているか否か
+}
+
+// Manifest 構造体は、ログローテーションしたアーカイブの一覧を取り扱う構造体
+type Manifest struct {
+	Segments []Segment `json:"segments"` // ログローテーションした順のアーカイブ
+}
+
+// マニフェストの読み書きを排他制御する。複数のロガーが同じマニフェストを使用する場合も考慮し、パッケージで1つとする
+var manifestMu sync.Mutex
+
+// ReadManifest : ManifestPath に書き込まれたマニフェストを読み込む
+func ReadManifest(path string) (*Manifest, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(buf, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Manifest : ManifestPath に書き込まれたマニフェストを読み込む
+func (l *Log) Manifest() (*Manifest, error) {
+	l.mu.Lock()
+	path := l.ManifestPath
+	l.mu.Unlock()
+	return ReadManifest(path)
+}
+
+// Find : from 以降、to より前に書き込まれたログを含む可能性のあるアーカイブを返却する。from, to がゼロ値の場合は制限しない
+func (m *Manifest) Find(from, to time.Time) []Segment {
+	var result []Segment
+	for _, seg := range m.Segments {
+		if seg.Covers(from, to) {
+			result = append(result, seg)
+		}
+	}
+	return result
+}
+
+// Covers : from 以降、to より前に書き込まれたログを含む可能性があるか否かを返却する。from, to がゼロ値の場合は制限しない
+func (s Segment) Covers(from, to time.Time) bool {
+	if !from.IsZero() && s.Last.Before(from) {
+		return false
+	}
+	if !to.IsZero() && !s.First.IsZero() && !s.First.Before(to) {
+		return false
+	}
+	return true
+}
+
+// Lookup : 指定したパスのアーカイブを返却する
+func (m *Manifest) Lookup(path string) (Segment, bool) {
+	if i := m.index(path); i != -1 {
+		return m.Segments[i], true
+	}
+	return Segment{}, false
+}
+
+func (m *Manifest) index(path string) int {
+	path = filepath.Clean(path)
+	for i, seg := range m.Segments {
+		if filepath.Clean(seg.Path) == path {
+			return i
+		}
+	}
+	return -1
+}
+
+// ManifestPath のマニフェストを読み込み、f で更新した後、書き込む。途中で中断しても壊れないよう、一時ファイルから置き換える。
+// ディレクトリ、一時ファイルは、ログファイルと同じく DirPerm, Perm, Owner, Group で作成する。l.mu をロックした状態で呼び出すこと
+func (l *Log) updateManifest(f func(m *Manifest)) error {
+	path := l.ManifestPath
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	m, err := ReadManifest(path)
+	if os.IsNotExist(err) {
+		m, err = &Manifest{}, nil
+	}
+	if err != nil {
+		return err
+	}
+	f(m)
+	buf, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir, _ := filepath.Split(path)
+	if err := l.mkdir(dir); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	fp, err := l.openfile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	_, err = fp.Write(append(buf, '\n'))
+	if err == nil {
+		err = fp.Sync()
+	}
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(dir)
+}
+
+// ログローテーションしたアーカイブをマニフェストへ追加する。
+// 追加書き込みした場合は、既存のアーカイブの情報を置き換える。l.mu をロックした状態で呼び出すこと
+func (l *Log) record(path string, now time.Time) error {
+	size, sum, lines, err := digest(path, true)
+	if err != nil {
+		return err
+	}
+	seg := Segment{Path: path, First: l.segFirst, Last: l.segLast, Lines: lines, Size: size, SHA256: sum, Compression: "none"}
+	if seg.Last.IsZero() {
+		seg.Last = now
+	}
+	overwrite := l.Overwrite
+	return l.updateManifest(func(m *Manifest) {
+		if i := m.index(path); i != -1 {
+			if !overwrite {
+				seg.First = m.Segments[i].First
+			}
+			m.Segments = append(m.Segments[:i], m.Segments[i+1:]...)
+		}
+		m.Segments = append(m.Segments, seg)
+	})
+}
+
+// アーカイブの圧縮、暗号化をマニフェストへ反映する。
+// 圧縮、暗号化したアーカイブへ追記した場合は、1つのアーカイブとしてまとめる。l.mu をロックした状態で呼び出すこと
+func (l *Log) renameSegment(from, to string) error {
+	if l.ManifestPath == "" {
+		return nil
+	}
+	size, sum, _, err := digest(to, false)
+	if err != nil {
+		return err
+	}
+	return l.updateManifest(func(m *Manifest) {
+		i := m.index(from)
+		if i == -1 {
+			return
+		}
+		seg := m.Segments[i]
+		if j := m.index(to); j != -1 {
+			prev := m.Segments[j]
+			seg.First = prev.First
+			seg.Lines += prev.Lines
+			m.Segments = append(m.Segments[:j], m.Segments[j+1:]...)
+			if j < i {
+				i--
+			}
+		}
+		seg.Path, seg.Size, seg.SHA256 = to, size, sum
+		switch {
+		case strings.HasSuffix(to, ".gz"):
+			seg.Compression = "gzip"
+		case strings.HasSuffix(to, encryptExt):
+			seg.Encrypted = true
+		}
+		m.Segments[i] = seg
+	})
+}
+
+// 削除したアーカイブをマニフェストから取り除く。l.mu をロックした状態で呼び出すこと
+func (l *Log) removeSegment(path string) error {
+	if l.ManifestPath == "" {
+		return nil
+	}
+	return l.updateManifest(func(m *Manifest) {
+		if i := m.index(path); i != -1 {
+			m.Segments = append(m.Segments[:i], m.Segments[i+1:]...)
+		}
+	})
+}
+
+// アーカイブのバイト数と SHA-256 を求める。lines が true の場合は、行数も求める
+func digest(path string, lines bool) (int64, string, int, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return 0, "", 0, err
+	}
+	defer fp.Close()
+	h := sha256.New()
+	var count lineCounter
+	var w io.Writer = h
+	if lines {
+		w = io.MultiWriter(h, &count)
+	}
+	size, err := io.Copy(w, fp)
+	if err != nil {
+		return 0, "", 0, err
+	}
+	return size, hex.EncodeToString(h.Sum(nil)), int(count), nil
+}
+
+// 書き込まれた行数を数える io.Writer
+type lineCounter int
+
+func (c *lineCounter) Write(b []byte) (int, error) {
+	*c += lineCounter(bytes.Count(b, []byte("\n")))
+	return len(b), nil
+}
+
+// アーカイブの一覧を、マニフェストに記録されたログローテーションの順に並べ替える。
+// マニフェストに記録されていないアーカイブは、記録されたアーカイブより古いものとして先頭に置く
+func (l *Log) manifestOrder(archives []string) []string {
+	l.mu.Lock()
+	path := l.ManifestPath
+	l.mu.Unlock()
+	if path == "" {
+		return archives
+	}
+	manifestMu.Lock()
+	m, err := ReadManifest(path)
+	manifestMu.Unlock()
+	if err != nil {
+		return archives
+	}
+	var result, listed []string
+	for _, a := range archives {
+		if m.index(a) == -1 {
+			result = append(result, a)
+		}
+	}
+	for _, seg := range m.Segments {
+		for _, a := range archives {
+			if filepath.Clean(a) == filepath.Clean(seg.Path) {
+				listed = append(listed, a)
+				break
+			}
+		}
+	}
+	return append(result, listed...)
+}
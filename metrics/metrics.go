@@ -0,0 +1,149 @@
+package metrics
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ochipin/logger"
+)
+
+// 統計情報を公開しているログ管理構造体
+var registry = struct {
+	sync.Mutex
+	vars    *expvar.Map
+	loggers map[string]logger.Statser
+}{loggers: map[string]logger.Statser{}}
+
+// Publish : ログ管理構造体の統計情報を、expvar の "logger" 変数へ name の名前で公開し、Handler の出力対象に登録する
+func Publish(name string, s logger.Statser) error {
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.loggers[name]; ok {
+		return fmt.Errorf("metrics: \"%s\" is already published", name)
+	}
+	if registry.vars == nil {
+		if expvar.Get("logger") != nil {
+			return fmt.Errorf("metrics: expvar \"logger\" is already used")
+		}
+		registry.vars = expvar.NewMap("logger")
+	}
+	registry.loggers[name] = s
+	registry.vars.Set(name, expvar.Func(func() interface{} { return s.Stats() }))
+	return nil
+}
+
+// Handler : 登録された全てのログ管理構造体の統計情報を、Prometheus のテキスト形式で出力する http.Handler を返却する
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Write(w)
+	})
+}
+
+// メトリクスの定義
+type metric struct {
+	name  string
+	help  string
+	typ   string
+	value func(name string, s logger.Stats) []sample
+}
+
+// メトリクスのラベルと値
+type sample struct {
+	labels string
+	value  string
+}
+
+var metricList = []metric{
+	{"logger_lines_total", "Number of log lines written per sink.", "counter", func(name string, s logger.Stats) []sample {
+		return perKey(name, "sink", s.Lines)
+	}},
+	{"logger_bytes_total", "Number of log bytes written per sink.", "counter", func(name string, s logger.Stats) []sample {
+		return perKey(name, "sink", s.Bytes)
+	}},
+	{"logger_errors_total", "Number of log write and rotation errors.", "counter", func(name string, s logger.Stats) []sample {
+		return []sample{{label("logger", name), fmt.Sprint(s.Errors)}}
+	}},
+	{"logger_dropped_total", "Number of log lines dropped without being written to the log file.", "counter", func(name string, s logger.Stats) []sample {
+		return []sample{{label("logger", name), fmt.Sprint(s.Dropped)}}
+	}},
+	{"logger_repeated_total", "Number of duplicate log lines folded into a repeat summary.", "counter", func(name string, s logger.Stats) []sample {
+		return []sample{{label("logger", name), fmt.Sprint(s.Repeated)}}
+	}},
+	{"logger_suppressed_total", "Number of log lines suppressed by rate limits.", "counter", func(name string, s logger.Stats) []sample {
+		return []sample{{label("logger", name), fmt.Sprint(s.Suppressed)}}
+	}},
+	{"logger_rotations_total", "Number of log rotations.", "counter", func(name string, s logger.Stats) []sample {
+		return []sample{{label("logger", name), fmt.Sprint(s.Rotations)}}
+	}},
+	{"logger_rotation_seconds_total", "Total time spent in log rotations.", "counter", func(name string, s logger.Stats) []sample {
+		return []sample{{label("logger", name), fmt.Sprint(s.RotationTime.Seconds())}}
+	}},
+	{"logger_uploads_total", "Number of archives uploaded.", "counter", func(name string, s logger.Stats) []sample {
+		return []sample{{label("logger", name), fmt.Sprint(s.Uploads)}}
+	}},
+	{"logger_write_seconds_total", "Total time spent writing to the log file.", "counter", func(name string, s logger.Stats) []sample {
+		return []sample{{label("logger", name), fmt.Sprint(s.WriteTime.Seconds())}}
+	}},
+	{"logger_level_lines_total", "Number of log lines written per level.", "counter", func(name string, s logger.Stats) []sample {
+		return perKey(name, "level", s.Levels)
+	}},
+}
+
+// Write : 登録された全てのログ管理構造体の統計情報を、Prometheus のテキスト形式で出力する
+func Write(w io.Writer) error {
+	registry.Lock()
+	var names []string
+	loggers := map[string]logger.Stats{}
+	for name, s := range registry.loggers {
+		names = append(names, name)
+		loggers[name] = s.Stats()
+	}
+	registry.Unlock()
+	sort.Strings(names)
+
+	for _, m := range metricList {
+		var samples []sample
+		for _, name := range names {
+			samples = append(samples, m.value(name, loggers[name])...)
+		}
+		if len(samples) == 0 {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ); err != nil {
+			return err
+		}
+		for _, s := range samples {
+			if _, err := fmt.Fprintf(w, "%s{%s} %s\n", m.name, s.labels, s.value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// キーごとの値を、キー名のラベルを付与したサンプルへ変換する
+func perKey(name, key string, values map[string]uint64) []sample {
+	var keys []string
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var result []sample
+	for _, k := range keys {
+		result = append(result, sample{label("logger", name) + "," + label(key, k), fmt.Sprint(values[k])})
+	}
+	return result
+}
+
+// Prometheus のラベルを生成する
+func label(key, value string) string {
+	rep := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return key + `="` + rep.Replace(value) + `"`
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"expvar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ochipin/logger/errorlog"
+)
+
+func TestMetrics(t *testing.T) {
+	log := &errorlog.Log{}
+	log.Path = "test/metrics.log"
+	log.Format = "%L: %M"
+	log.Level = 6
+
+	l, err := log.MakeLog(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Error("Hello World")
+	l.Info("Hello World")
+	l.Debug("Hello World")
+
+	if err := Publish("app", log); err != nil {
+		t.Fatal(err)
+	}
+	if err := Publish("app", log); err == nil {
+		t.Fatal("duplicated name must be error")
+	}
+	if v := expvar.Get("logger"); v == nil || !strings.Contains(v.String(), `"app"`) {
+		t.Fatal("expvar is not published")
+	}
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, expect := range []string{
+		"# TYPE logger_lines_total counter\n",
+		`logger_lines_total{logger="app",sink="file"} 2` + "\n",
+		`logger_bytes_total{logger="app",sink="file"} 37` + "\n",
+		`logger_level_lines_total{logger="app",level="error"} 1` + "\n",
+		`logger_level_lines_total{logger="app",level="debug"} 0` + "\n",
+		`logger_errors_total{logger="app"} 0` + "\n",
+	} {
+		if !strings.Contains(body, expect) {
+			t.Fatalf("%q is not found in\n%s", expect, body)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+// LookupOwner : 所有者、グループ名(または数値ID)から、ユーザID、グループIDを取得する。未指定の場合は -1 を返却する。
+// 設定ファイルの検証等、Owner, Group を反映する前に確認する場合にも使用する
+func LookupOwner(owner, group string) (int, int, error) {
+	uid, gid := -1, -1
+	if owner != "" {
+		id, err := strconv.Atoi(owner)
+		if err != nil {
+			u, lerr := user.Lookup(owner)
+			if lerr != nil {
+				return 0, 0, fmt.Errorf("logger: owner \"%s\" is not found", owner)
+			}
+			id, _ = strconv.Atoi(u.Uid)
+		}
+		uid = id
+	}
+	if group != "" {
+		id, err := strconv.Atoi(group)
+		if err != nil {
+			g, lerr := user.LookupGroup(group)
+			if lerr != nil {
+				return 0, 0, fmt.Errorf("logger: group \"%s\" is not found", group)
+			}
+			id, _ = strconv.Atoi(g.Gid)
+		}
+		gid = id
+	}
+	return uid, gid, nil
+}
+
+// ディレクトリを DirPerm で作成し、作成したディレクトリの所有者を Owner, Group に変更する。
+// l.mu をロックした状態で呼び出すこと
+func (l *Log) mkdir(dir string) error {
+	if dir == "" {
+		return nil
+	}
+	// 存在しないディレクトリを、親ディレクトリから順に列挙する
+	var created []string
+	for d := filepath.Clean(dir); ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil || d == filepath.Dir(d) {
+			break
+		}
+		created = append([]string{d}, created...)
+	}
+	perm := l.DirPerm
+	if perm == 0 {
+		perm = 0755
+	}
+	if err := os.MkdirAll(dir, os.FileMode(perm)); err != nil {
+		return err
+	}
+	for _, d := range created {
+		// umask の影響を受けないよう、作成したディレクトリのパーミッションを DirPerm に合わせる
+		if err := os.Chmod(d, os.FileMode(perm)); err != nil {
+			return err
+		}
+		if err := l.chown(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ファイル、ディレクトリの所有者を Owner, Group に変更する。l.mu をロックした状態で呼び出すこと
+func (l *Log) chown(path string) error {
+	uid, gid := l.owner()
+	return chown(path, uid, gid)
+}
+
+// Owner, Group から求めたユーザID、グループIDを返却する。未指定の場合は -1 を返却する
+func (l *Log) owner() (int, int) {
+	uid, gid := l.uid, l.gid
+	if l.Owner == "" {
+		uid = -1
+	}
+	if l.Group == "" {
+		gid = -1
+	}
+	return uid, gid
+}
+
+// ファイル、ディレクトリの所有者を変更する。uid, gid が共に -1 の場合は変更しない
+func chown(path string, uid, gid int) error {
+	if uid == -1 && gid == -1 {
+		return nil
+	}
+	return os.Chown(path, uid, gid)
+}
+
+// ファイルを Perm で開く。ファイルを作成した場合は所有者を変更し、既存のファイルの場合はパーミッション、所有者を検証する。
+// l.mu をロックした状態で呼び出すこと
+func (l *Log) openfile(path string, flag int) (*os.File, error) {
+	_, err := os.Lstat(path)
+	created := os.IsNotExist(err)
+	fp, err := os.OpenFile(path, flag, os.FileMode(l.Perm))
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		// umask の影響を受けないよう、作成したファイルのパーミッションを Perm に合わせる
+		if info, serr := fp.Stat(); serr == nil && info.Mode().Perm() != os.FileMode(l.Perm) {
+			err = fp.Chmod(os.FileMode(l.Perm))
+		}
+		if err == nil {
+			err = l.chown(path)
+		}
+	} else if info, serr := fp.Stat(); serr == nil {
+		l.drift(path, info)
+	}
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+	return fp, nil
+}
+
+// 既存のファイルのパーミッション、所有者が設定と異なる場合は、OnError とシスログへ通知する。
+// 同じ差異は、ファイルが設定と一致するまで1度だけ通知する。l.mu をロックした状態で呼び出すこと
+func (l *Log) drift(path string, info os.FileInfo) {
+	var message string
+	if perm := info.Mode().Perm(); perm != os.FileMode(l.Perm) {
+		message = fmt.Sprintf("permission %04o differs from %04o", perm, l.Perm)
+	}
+	wuid, wgid := l.owner()
+	if uid, gid, ok := fileOwner(info); ok && ((wuid != -1 && uid != wuid) || (wgid != -1 && gid != wgid)) {
+		if message != "" {
+			message += ", "
+		}
+		message += fmt.Sprintf("owner %d:%d differs from %s:%s", uid, gid, idString(wuid, uid), idString(wgid, gid))
+	}
+
+	if l.drifts == nil {
+		l.drifts = map[string]string{}
+	}
+	if message == l.drifts[path] {
+		return
+	}
+	if message == "" {
+		delete(l.drifts, path)
+		return
+	}
+	l.drifts[path] = message
+	err := fmt.Errorf("logger: %s: %s", path, message)
+	l.errs = append(l.errs, err)
+	l.alert(err.Error())
+}
+
+// 所有者の設定値を文字列へ変換する。未指定の場合は、ファイルの所有者と同じとみなす
+func idString(want, actual int) string {
+	if want == -1 {
+		return strconv.Itoa(actual)
+	}
+	return strconv.Itoa(want)
+}
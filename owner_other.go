@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package logger
+
+import "os"
+
+// 所有者の取得に対応していない環境では、所有者を比較しない
+func fileOwner(info os.FileInfo) (int, int, bool) {
+	return 0, 0, false
+}
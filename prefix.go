@@ -0,0 +1,62 @@
+package logger
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Prefix の日時の書式のデフォルト値
+const prefixLayout = "2006-01-02 15:04:05"
+
+// Prefix の %t に使用する日時の書式を返却する。TimePrecision が指定されている場合は、秒の後ろへ小数点以下の桁を付与する
+func (l *Log) timeLayout() string {
+	layout := l.TimeLayout
+	if layout == "" {
+		layout = prefixLayout
+	}
+	if l.TimePrecision > 0 && !strings.Contains(layout, "05.") {
+		n := l.TimePrecision
+		if n > 9 {
+			n = 9
+		}
+		layout = strings.Replace(layout, "05", "05."+strings.Repeat("0", n), 1)
+	}
+	return layout
+}
+
+// Prefix の各指定子を置き換えた、ログの先頭へ付与する文字列を生成する。l.mu をロックした状態で呼び出すこと
+func (l *Log) prefix(now time.Time) string {
+	l.seq++
+	var b strings.Builder
+	for i := 0; i < len(l.Prefix); i++ {
+		c := l.Prefix[i]
+		if c != '%' || i+1 == len(l.Prefix) {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		switch l.Prefix[i] {
+		case 't':
+			b.WriteString(now.Format(l.timeLayout()))
+		case 'h':
+			if l.hostname == "" {
+				l.hostname, _ = os.Hostname()
+			}
+			b.WriteString(l.hostname)
+		case 'p':
+			b.WriteString(strconv.Itoa(os.Getpid()))
+		case 's':
+			b.WriteString(strconv.FormatUint(l.seq, 10))
+		case 'n':
+			b.WriteString(l.Name)
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(l.Prefix[i])
+		}
+	}
+	return b.String()
+}
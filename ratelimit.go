@@ -0,0 +1,98 @@
+package logger
+
+import (
+	"fmt"
+	"time"
+)
+
+// 出力を制限したログの集計行を出力する間隔のデフォルト値
+const rateReport = 10 * time.Second
+
+// RateLimiter 構造体は、トークンバケットにより出力を制限する構造体。
+// 同時に呼び出す場合は、呼び出し側で排他制御を行うこと
+type RateLimiter struct {
+	Rate   float64   // 1秒あたりに補充するトークン数。0 以下の場合は制限しない
+	Burst  int       // バケットの容量。1 未満の場合は 1 とみなす
+	tokens float64   // バケットに残っているトークン数
+	last   time.Time // 最後にトークンを補充した時刻
+}
+
+// Allow : トークンを1つ消費できれば true を返却する
+func (r *RateLimiter) Allow(now time.Time) bool {
+	if r.Rate <= 0 {
+		return true
+	}
+	burst := float64(r.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	if r.last.IsZero() {
+		r.tokens = burst
+	} else if elapsed := now.Sub(r.last).Seconds(); elapsed > 0 {
+		r.tokens += elapsed * r.Rate
+	}
+	if r.tokens > burst {
+		r.tokens = burst
+	}
+	r.last = now
+	if r.tokens < 1 {
+		return false
+	}
+	r.tokens--
+	return true
+}
+
+// RateReport の間隔を返却する
+func (l *Log) reportWait() time.Duration {
+	if l.RateReport <= 0 {
+		return rateReport
+	}
+	return l.RateReport
+}
+
+// RateLimit を超えたログを出力せずに集計する。出力してよい場合は true を返却する。l.mu をロックした状態で呼び出すこと
+func (l *Log) limit() bool {
+	l.limiter.Rate, l.limiter.Burst = l.RateLimit, l.RateBurst
+	if l.limiter.Allow(time.Now()) {
+		return true
+	}
+	l.suppressed++
+	l.stats.suppressed++
+	// 制限したログの行数を、定期的に出力する
+	if l.rateTimer == nil {
+		l.rateTimer = time.AfterFunc(l.reportWait(), func() { l.report(l.suppressReport) })
+	}
+	return false
+}
+
+// 制限したログがあれば、"rate limit exceeded, suppressed N lines" を出力する。l.mu をロックした状態で呼び出すこと
+func (l *Log) suppressReport() error {
+	if l.rateTimer != nil {
+		l.rateTimer.Stop()
+		l.rateTimer = nil
+	}
+	if l.suppressed == 0 {
+		return nil
+	}
+	count := l.suppressed
+	l.suppressed = 0
+	return l.emit(fmt.Sprintf("rate limit exceeded, suppressed %d lines", count))
+}
+
+// タイマー等から、ロックを取得して f を実行する。書き込みエラーは、ロックを解放してから通知する
+func (l *Log) report(f func() error) {
+	l.mu.Lock()
+	err := f()
+	hook, errs := l.OnError, l.errs
+	l.errs = nil
+	l.mu.Unlock()
+
+	if hook != nil {
+		for _, e := range errs {
+			hook(e)
+		}
+	}
+	if err != nil {
+		l.alert("logger: " + err.Error())
+	}
+}
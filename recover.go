@@ -0,0 +1,176 @@
+package logger
+
+import (
+	"fmt"
+	"time"
+)
+
+// ログ情報をログファイルへ書き込む。書き込めない場合は、スプールへ退避して再試行するか、代替パスへの書き込み、またはスプールを行う。
+// l.mu をロックした状態で呼び出すこと
+func (l *Log) store(s string) error {
+	// 空き容量が不足している場合、ファイルへの書き込みを停止する
+	if l.diskFull {
+		l.stats.dropped++
+		return nil
+	}
+	// 1. スプールされたログがあれば、順序を保つため先に書き込む
+	if len(l.spool) != 0 {
+		if err := l.replay(); err != nil {
+			return l.escape(s, err)
+		}
+	}
+
+	// 2. ログファイルへ書き込む
+	err := l.savefile(s)
+	if err == nil {
+		if l.failing {
+			l.failing = false
+			l.alert("logger: log file is writable again")
+		}
+		return nil
+	}
+	l.stats.errors++
+	// 空き容量不足で書き込めなかった場合、空き容量が回復するまで書き込みを停止する
+	if l.guarding && isDiskFull(err) {
+		l.stats.dropped++
+		l.diskFull = true
+		return fmt.Errorf("%v: stop writing log file until free disk space is available", err)
+	}
+	// 3. 再試行する場合は、他のログ出力を止めないよう、ロックしたまま待たずにスプールへ退避し、別の goroutine で再試行する。
+	// 書き込めない状態が続いている場合は、再試行しない
+	if l.RetryCount > 0 && !l.failing && !l.retrying {
+		l.errs = append(l.errs, err)
+		l.spool = append(l.spool, s)
+		l.retry()
+		return nil
+	}
+	return l.escape(s, err)
+}
+
+// RetryWait の間隔で、スプールしたログの書き込みを RetryCount 回まで再試行する goroutine を起動する。
+// l.mu をロックした状態で呼び出すこと
+func (l *Log) retry() {
+	l.retrying = true
+	count, wait := l.RetryCount, l.RetryWait
+	go func() {
+		var err error
+		for i := 0; i < count; i++ {
+			time.Sleep(wait)
+			l.mu.Lock()
+			// 後続のログ出力、Flush により書き込まれた場合は終了する
+			if len(l.spool) == 0 {
+				l.retrying = false
+				l.mu.Unlock()
+				return
+			}
+			l.stats.errors++
+			if err = l.replay(); err == nil {
+				l.retrying = false
+				l.mu.Unlock()
+				return
+			}
+			l.errs = append(l.errs, err)
+			l.mu.Unlock()
+		}
+
+		// 再試行しても書き込めない場合は、SpoolSize を超えたログを代替パスへ書き込むか、破棄する
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.retrying = false
+		l.fail(err)
+		for len(l.spool) > 0 && len(l.spool) > l.SpoolSize {
+			s := l.spool[0]
+			l.spool = l.spool[1:]
+			if l.FallbackPath != "" {
+				if ferr := l.writefile(l.FallbackPath, s); ferr == nil {
+					l.stats.count(sinkFallback, s)
+					continue
+				}
+				l.stats.errors++
+			}
+			l.stats.dropped++
+		}
+	}()
+}
+
+// ログファイルへ書き込めない状態とし、書き込めるようになるまで以降のログは再試行しない。l.mu をロックした状態で呼び出すこと
+func (l *Log) fail(err error) {
+	if l.failing {
+		return
+	}
+	l.failing = true
+	if l.FallbackPath != "" || l.SpoolSize > 0 {
+		l.alert("logger: " + err.Error() + ": log file is not writable, escape logs until recovery")
+	} else {
+		l.alert("logger: " + err.Error() + ": log file is not writable, drop logs until recovery")
+	}
+}
+
+// ログファイルへ書き込めなかったログを、代替パスへ書き込むか、スプールへ退避する。
+// どちらも行えず、ログが失われた場合はエラーを返却する。以降のログは、書き込めるようになるまで再試行しない
+func (l *Log) escape(s string, err error) error {
+	l.errs = append(l.errs, err)
+	// 再試行中は、再試行を終えた時点で書き込めない状態とする
+	if !l.retrying {
+		l.fail(err)
+	}
+	// 1. 代替パスへ書き込む
+	if l.FallbackPath != "" {
+		ferr := l.writefile(l.FallbackPath, s)
+		if ferr == nil {
+			l.stats.count(sinkFallback, s)
+			return nil
+		}
+		l.stats.errors++
+		l.errs = append(l.errs, ferr)
+	}
+	// 2. スプールへ退避する。スプールが一杯の場合は、古いログから破棄する
+	if l.SpoolSize <= 0 {
+		l.stats.dropped++
+		return err
+	}
+	if len(l.spool) >= l.SpoolSize {
+		drop := len(l.spool) - l.SpoolSize + 1
+		l.spool = l.spool[drop:]
+		l.stats.dropped += uint64(drop)
+		l.errs = append(l.errs, fmt.Errorf("logger: spool is full, dropped %d lines", drop))
+	}
+	l.spool = append(l.spool, s)
+	return nil
+}
+
+// スプールされたログを、ログファイルへ順に書き込む。l.mu をロックした状態で呼び出すこと
+func (l *Log) replay() error {
+	count := len(l.spool)
+	for len(l.spool) != 0 {
+		if err := l.savefile(l.spool[0]); err != nil {
+			l.stats.errors++
+			return err
+		}
+		l.spool = l.spool[1:]
+	}
+	l.spool = nil
+	l.failing = false
+	l.alert(fmt.Sprintf("logger: log file is writable again, replayed %d lines", count))
+	return nil
+}
+
+// Flush : Dedup により集計中のログの集計行、RateLimit により制限したログの行数と、スプールされたログを、ログファイルへ書き込む。
+// SyncPolicy により fsync を遅らせているファイルは、fsync する
+func (l *Log) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.repeated()
+	if e := l.suppressReport(); e != nil {
+		err = e
+	}
+	if len(l.spool) != 0 && l.Path != "" {
+		if e := l.replay(); e != nil {
+			err = e
+		}
+	}
+	if e := l.sync(); e != nil {
+		err = e
+	}
+	return err
+}
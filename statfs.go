@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package logger
+
+import "syscall"
+
+// 指定されたディレクトリを含むファイルシステムの空き容量(byte)を取得する
+func freeSpace(dir string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * blockSize(&st), nil
+}
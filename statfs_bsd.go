@@ -0,0 +1,11 @@
+//go:build darwin || freebsd
+// +build darwin freebsd
+
+package logger
+
+import "syscall"
+
+// 空き容量の計算に使用するブロックサイズを取得する。Bsize が Bavail の単位となる
+func blockSize(st *syscall.Statfs_t) uint64 {
+	return uint64(st.Bsize)
+}
@@ -0,0 +1,14 @@
+//go:build linux
+// +build linux
+
+package logger
+
+import "syscall"
+
+// 空き容量の計算に使用するブロックサイズを取得する。Bavail はフラグメント単位のため、Frsize を優先する
+func blockSize(st *syscall.Statfs_t) uint64 {
+	if st.Frsize > 0 {
+		return uint64(st.Frsize)
+	}
+	return uint64(st.Bsize)
+}
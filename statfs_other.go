@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package logger
+
+import "fmt"
+
+// 空き容量の取得に対応していない環境では、常にエラーを返却する
+func freeSpace(dir string) (uint64, error) {
+	return 0, fmt.Errorf("free disk space is not supported on this platform")
+}
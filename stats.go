@@ -0,0 +1,78 @@
+package logger
+
+import "time"
+
+// ログの出力先
+const (
+	sinkConsole  = iota // 標準出力/標準エラー出力
+	sinkFile            // ログファイル
+	sinkFallback        // 代替パス
+	sinkCount
+)
+
+// 出力先の名前
+var sinkNames = [sinkCount]string{"console", "file", "fallback"}
+
+// Stats 構造体は、ログ出力の統計情報を取り扱う構造体
+type Stats struct {
+	Lines        map[string]uint64 `json:"lines"`         // 出力先(console/file/fallback)ごとの出力行数
+	Bytes        map[string]uint64 `json:"bytes"`         // 出力先ごとの出力バイト数
+	Errors       uint64            `json:"errors"`        // ログファイルへの書き込み、ログローテーションのエラー数
+	Dropped      uint64            `json:"dropped"`       // ログファイルへ書き込めずに破棄したログの行数
+	Repeated     uint64            `json:"repeated"`      // Dedup により、出力せずに集計したログの行数
+	Suppressed   uint64            `json:"suppressed"`    // RateLimit 等により、出力を制限したログの行数
+	Rotations    uint64            `json:"rotations"`     // ログローテーション回数
+	Uploads      uint64            `json:"uploads"`       // アップロードしたアーカイブの数
+	RotationTime time.Duration     `json:"rotation_time"` // ログローテーションに要した時間の合計
+	WriteTime    time.Duration     `json:"write_time"`    // ログファイルへの書き込みに要した時間の合計
+	Levels       map[string]uint64 `json:"levels"`        // ログレベルごとの出力行数。errorlog のみ集計する
+}
+
+// Statser : 統計情報を取得できるログ管理構造体のインタフェース
+type Statser interface {
+	Stats() Stats
+}
+
+// ログ出力の統計情報。l.mu をロックした状態で更新する
+type stats struct {
+	lines        [sinkCount]uint64
+	bytes        [sinkCount]uint64
+	errors       uint64
+	dropped      uint64
+	repeated     uint64
+	suppressed   uint64
+	rotations    uint64
+	uploads      uint64
+	rotationTime time.Duration
+	writeTime    time.Duration
+}
+
+// 出力先ごとの出力行数、バイト数を加算する
+func (s *stats) count(sink int, line string) {
+	s.lines[sink]++
+	s.bytes[sink] += uint64(len(line) + 1)
+}
+
+// Stats : ログ出力の統計情報を取得する
+func (l *Log) Stats() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	result := Stats{
+		Lines:        map[string]uint64{},
+		Bytes:        map[string]uint64{},
+		Errors:       l.stats.errors,
+		Dropped:      l.stats.dropped,
+		Repeated:     l.stats.repeated,
+		Suppressed:   l.stats.suppressed,
+		Rotations:    l.stats.rotations,
+		Uploads:      l.stats.uploads,
+		RotationTime: l.stats.rotationTime,
+		WriteTime:    l.stats.writeTime,
+	}
+	for i, name := range sinkNames {
+		result.Lines[name] = l.stats.lines[i]
+		result.Bytes[name] = l.stats.bytes[i]
+	}
+	return result
+}
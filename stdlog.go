@@ -0,0 +1,111 @@
+package logger
+
+import (
+	"log"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// StdWriter 構造体は、標準ライブラリ log パッケージの出力から、プレフィックスと日時、ファイル名等を取り除いて出力する構造体
+type StdWriter struct {
+	Std    *log.Logger                                           // プレフィックスとフラグを参照する *log.Logger
+	Output func(file, funcname string, line int, s string) error // 取り除いた後のメッセージと、log パッケージの呼び出し元の情報を受け取る関数
+}
+
+// Write : log パッケージから渡された1行を、プレフィックスと日時、ファイル名等を取り除いて Output へ渡す
+func (w *StdWriter) Write(b []byte) (int, error) {
+	prefix, flag := "", 0
+	if w.Std != nil {
+		prefix, flag = w.Std.Prefix(), w.Std.Flags()
+	}
+	hfile, hline, message := StripStd(string(b), prefix, flag)
+	// log パッケージの呼び出し元を取得する。取得できない場合は、ファイル名と行番号を出力内容から取得する
+	file, funcname, line := caller()
+	if file == "???" && hfile != "" {
+		file, line = hfile[strings.LastIndex(hfile, "/")+1:], hline
+	}
+	if err := w.Output(file, funcname, line, message); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// log パッケージを呼び出した関数のソースファイル名、関数名、行番号を取得する
+func caller() (string, string, int) {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		frame, more := frames.Next()
+		// StdWriter, log パッケージ内の関数は読み飛ばす
+		if !strings.HasPrefix(frame.Function, "log.") && !strings.HasPrefix(frame.Function, "github.com/ochipin/logger.(*StdWriter)") {
+			funcname := frame.Function[strings.LastIndex(frame.Function, ".")+1:]
+			filename := frame.File[strings.LastIndex(frame.File, "/")+1:]
+			return filename, funcname, frame.Line
+		}
+		if !more {
+			return "???", "???", 0
+		}
+	}
+}
+
+// StripStd : log パッケージが出力した1行から、プレフィックスと日時、ファイル名と行番号を取り除く。
+// ファイル名と行番号が出力されていた場合は、それらも返却する
+func StripStd(s, prefix string, flag int) (string, int, string) {
+	s = strings.TrimSuffix(s, "\n")
+	// 1. 先頭のプレフィックスを取り除く
+	if flag&log.Lmsgprefix == 0 {
+		s = strings.TrimPrefix(s, prefix)
+	}
+	// 2. 日時を取り除く ex) 2009/01/23 01:23:23.123123
+	if flag&log.Ldate != 0 && len(s) >= 11 {
+		s = s[11:]
+	}
+	if flag&(log.Ltime|log.Lmicroseconds) != 0 {
+		n := 9
+		if flag&log.Lmicroseconds != 0 {
+			n += 7
+		}
+		if len(s) >= n {
+			s = s[n:]
+		}
+	}
+	// 3. ファイル名と行番号を取り除く ex) main.go:23:
+	var file string
+	var line int
+	if flag&(log.Lshortfile|log.Llongfile) != 0 {
+		for off := 0; ; {
+			i := strings.Index(s[off:], ": ")
+			if i == -1 {
+				break
+			}
+			i += off
+			if idx := strings.LastIndex(s[:i], ":"); idx != -1 {
+				if n, err := strconv.Atoi(s[idx+1 : i]); err == nil {
+					file, line, s = s[:idx], n, s[i+2:]
+					break
+				}
+			}
+			off = i + 2
+		}
+	}
+	// 4. メッセージ直前のプレフィックスを取り除く
+	if flag&log.Lmsgprefix != 0 {
+		s = strings.TrimPrefix(s, prefix)
+	}
+	return file, line, s
+}
+
+// StdLogger : ログ管理構造体へ出力する *log.Logger を生成する。
+// SetPrefix, SetFlags で指定したプレフィックス、日時等は出力前に取り除かれる
+func (l *Log) StdLogger() *log.Logger {
+	std := log.New(nil, "", 0)
+	std.SetOutput(&StdWriter{Std: std, Output: func(file, funcname string, line int, s string) error {
+		if err := l.output("", s); err != nil {
+			l.alert("logger: " + err.Error())
+			return err
+		}
+		return nil
+	}})
+	return std
+}
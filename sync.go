@@ -0,0 +1,120 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SyncPolicy 構造体は、ログファイルを fsync するタイミングを取り扱う構造体。ゼロ値の場合は fsync せず、OS に任せる
+type SyncPolicy struct {
+	Always   bool          // 書き込みのたびに fdatasync する
+	Lines    int           // この行数を書き込むたびに fsync する
+	Interval time.Duration // 書き込んでから、この時間が経過するまでに fsync する
+}
+
+// ParseSyncPolicy : "none", "always", "100"(行数), "1s"(間隔) の形式で指定された文字列から SyncPolicy を生成する
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "", "none":
+		return SyncPolicy{}, nil
+	case "always":
+		return SyncPolicy{Always: true}, nil
+	}
+	if n, err := strconv.Atoi(s); err == nil && n > 0 {
+		return SyncPolicy{Lines: n}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return SyncPolicy{Interval: d}, nil
+	}
+	return SyncPolicy{}, fmt.Errorf("logger: sync policy \"%s\" is invalid", s)
+}
+
+// String : ParseSyncPolicy で読み込める形式の文字列を返却する
+func (p SyncPolicy) String() string {
+	switch {
+	case p.Always:
+		return "always"
+	case p.Lines > 0:
+		return strconv.Itoa(p.Lines)
+	case p.Interval > 0:
+		return p.Interval.String()
+	}
+	return "none"
+}
+
+// 書き込んだファイルを、SyncPolicy に従って fsync する。l.mu をロックした状態で呼び出すこと
+func (l *Log) dirty(path string) {
+	p := l.SyncPolicy
+	if p.Always || (p.Lines <= 0 && p.Interval <= 0) {
+		return
+	}
+	if l.unsynced == nil {
+		l.unsynced = map[string]bool{}
+	}
+	l.unsynced[path] = true
+	// サイドカーファイルへの書き込みは、行数に含めない
+	if !strings.HasSuffix(path, chainExt) {
+		l.unsyncedLines++
+	}
+	if p.Lines > 0 && l.unsyncedLines >= p.Lines {
+		if err := l.sync(); err != nil {
+			l.stats.errors++
+			l.errs = append(l.errs, err)
+			l.alert("logger: " + err.Error())
+		}
+		return
+	}
+	if p.Interval > 0 && l.syncTimer == nil {
+		l.syncTimer = time.AfterFunc(p.Interval, func() { l.report(l.sync) })
+	}
+}
+
+// fsync していないファイルを fsync する。l.mu をロックした状態で呼び出すこと
+func (l *Log) sync() error {
+	if l.syncTimer != nil {
+		l.syncTimer.Stop()
+		l.syncTimer = nil
+	}
+	var err error
+	for path := range l.unsynced {
+		if e := syncFile(path); e != nil && err == nil {
+			err = e
+		}
+	}
+	l.unsynced = nil
+	l.unsyncedLines = 0
+	return err
+}
+
+// ファイルを fsync する
+func syncFile(path string) error {
+	fp, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	err = fp.Sync()
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// ディレクトリを fsync し、ファイルの作成、削除を永続化する
+func syncDir(dir string) error {
+	if dir == "" {
+		dir = "."
+	}
+	fp, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = fp.Sync()
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
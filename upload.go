@@ -0,0 +1,218 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Uploader : ログローテーションしたアーカイブを、外部のストレージへアップロードするインタフェース。
+// S3 互換のストレージ、ローカルディレクトリへの実装は、upload ライブラリを使用する
+type Uploader interface {
+	Upload(ctx context.Context, path string) error
+}
+
+// アップロードに失敗した場合に、再試行するまでの待ち時間
+const uploadRetry = time.Minute
+
+// UploadRetry の待ち時間を返却する
+func (l *Log) uploadWait() time.Duration {
+	if l.UploadRetry <= 0 {
+		return uploadRetry
+	}
+	return l.UploadRetry
+}
+
+// アーカイブをアップロード待ちに追加し、アップロードを実施する goroutine を起動する。l.mu をロックした状態で呼び出すこと
+func (l *Log) enqueue(path string) {
+	if l.Uploader == nil {
+		return
+	}
+	l.loadQueue()
+	for i, p := range l.uploads {
+		if p == path {
+			// アップロード中のアーカイブへ追記された場合は、アップロード後に再度アップロードする
+			if i == 0 && l.shipping {
+				l.reupload = true
+			}
+			return
+		}
+	}
+	l.uploads = append(l.uploads, path)
+	if err := l.saveQueue(); err != nil {
+		l.stats.errors++
+		l.alert("logger: " + err.Error())
+	}
+	l.ship()
+}
+
+// 削除したアーカイブを、アップロード待ちから取り除く。圧縮前のアーカイブ名で記録されたものも取り除く。
+// l.mu をロックした状態で呼び出すこと
+func (l *Log) dequeue(path string) {
+	uploads := l.uploads[:0]
+	for _, p := range l.uploads {
+		if p != path && p+".gz" != path {
+			uploads = append(uploads, p)
+		}
+	}
+	l.uploads = uploads
+}
+
+// UploadQueue に記録されたアップロード待ちのアーカイブを読み込む。l.mu をロックした状態で呼び出すこと
+func (l *Log) loadQueue() {
+	if l.queueLoaded {
+		return
+	}
+	l.queueLoaded = true
+	if l.UploadQueue == "" {
+		return
+	}
+	buf, err := ioutil.ReadFile(l.UploadQueue)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(buf), "\n") {
+		if line != "" {
+			l.uploads = append(l.uploads, line)
+		}
+	}
+}
+
+// アップロード待ちのアーカイブを UploadQueue へ書き込む。途中で中断しても壊れないよう、一時ファイルから置き換える。
+// l.mu をロックした状態で呼び出すこと
+func (l *Log) saveQueue() error {
+	if l.UploadQueue == "" {
+		return nil
+	}
+	var buf []byte
+	for _, path := range l.uploads {
+		buf = append(buf, path+"\n"...)
+	}
+	dir, _ := filepath.Split(l.UploadQueue)
+	if err := l.mkdir(dir); err != nil {
+		return err
+	}
+	tmp := l.UploadQueue + ".tmp"
+	fp, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(l.Perm))
+	if err != nil {
+		return err
+	}
+	_, err = fp.Write(buf)
+	if err == nil {
+		err = fp.Sync()
+	}
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, l.UploadQueue)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(dir)
+}
+
+// アップロードを実施する goroutine を起動する。l.mu をロックした状態で呼び出すこと
+func (l *Log) ship() {
+	if l.Uploader == nil || l.shipping {
+		return
+	}
+	l.loadQueue()
+	if len(l.uploads) == 0 {
+		return
+	}
+	l.shipping = true
+
+	go func() {
+		for {
+			l.mu.Lock()
+			// アップロード待ちのアーカイブがなくなった場合は、goroutine を終了する
+			if l.Uploader == nil || len(l.uploads) == 0 {
+				l.shipping = false
+				l.mu.Unlock()
+				return
+			}
+			path, uploader, remove, wait := l.uploads[0], l.Uploader, l.UploadDelete, l.uploadWait()
+			l.reupload = false
+			l.mu.Unlock()
+
+			uploaded, info, err := upload(uploader, path)
+
+			// ログローテーションによる追記と重ならないよう、アップロード後の確認と削除はロックした状態で行う
+			l.mu.Lock()
+			// 削除されたアーカイブは、後続のアーカイブのアップロードを止めないよう、アップロード待ちから取り除く
+			if info == nil && errors.Is(err, os.ErrNotExist) {
+				l.stats.errors++
+				l.dequeue(path)
+				serr := l.saveQueue()
+				l.mu.Unlock()
+				l.alert("logger: " + err.Error() + ": removed from upload queue")
+				if serr != nil {
+					l.alert("logger: " + serr.Error())
+				}
+				continue
+			}
+			if err == nil {
+				l.stats.uploads++
+				// アップロード中に追記、変更された場合は、削除せずに再度アップロードする
+				if !l.reupload && !modified(uploaded, info) {
+					if remove {
+						err = l.removeUploaded(uploaded)
+					}
+					if err == nil && len(l.uploads) != 0 && l.uploads[0] == path {
+						l.uploads = l.uploads[1:]
+						err = l.saveQueue()
+					}
+				}
+			}
+			if err != nil {
+				l.stats.errors++
+			}
+			l.mu.Unlock()
+
+			// アップロードに失敗した場合は、待ち時間の経過後に再試行する
+			if err != nil {
+				l.alert("logger: " + err.Error())
+				time.Sleep(wait)
+			}
+		}
+	}()
+}
+
+// アーカイブをアップロードし、アップロードしたアーカイブのパスと、アップロード前のファイル情報を返却する。
+// アップロード前に圧縮されたアーカイブは、圧縮後のアーカイブをアップロードする
+func upload(uploader Uploader, path string) (string, os.FileInfo, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		if gz, gzerr := os.Stat(path + ".gz"); gzerr == nil {
+			path, info, err = path+".gz", gz, nil
+		}
+	}
+	if err != nil {
+		return path, nil, err
+	}
+	if err := uploader.Upload(context.Background(), path); err != nil {
+		return path, nil, err
+	}
+	return path, info, nil
+}
+
+// アップロード前からサイズ、更新日時が変わったか判定する
+func modified(path string, info os.FileInfo) bool {
+	now, err := os.Stat(path)
+	return err == nil && (now.Size() != info.Size() || !now.ModTime().Equal(info.ModTime()))
+}
+
+// アップロードしたアーカイブを削除し、マニフェストから取り除く。l.mu をロックした状態で呼び出すこと
+func (l *Log) removeUploaded(path string) error {
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	return l.removeSegment(path)
+}
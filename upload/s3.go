@@ -0,0 +1,179 @@
+package upload
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3 構造体は、ログローテーションしたアーカイブを、S3 互換のオブジェクトストレージへアップロードする構造体。
+// リクエストは AWS Signature Version 4 で署名し、パス形式(<Endpoint>/<Bucket>/<キー>)の URL へ PUT する
+type S3 struct {
+	Endpoint     string              // ex) https://s3.ap-northeast-1.amazonaws.com, http://minio:9000
+	Region       string              // リージョン。未指定の場合は us-east-1
+	Bucket       string              // バケット名
+	AccessKey    string              // アクセスキーID
+	SecretKey    string              // シークレットアクセスキー
+	SessionToken string              // 一時的な認証情報のセッショントークン
+	Prefix       string              // オブジェクトキーへ付与する文字列 ex) "app/"
+	Key          func(string) string // アーカイブのパスから、オブジェクトキーを求める関数。nil の場合は Prefix + ファイル名
+	Client       *http.Client        // リクエストに使用する http.Client。nil の場合は http.DefaultClient
+	now          func() time.Time    // 署名に使用する日時。テスト時に差し替える
+}
+
+// Upload : アーカイブをアップロードする。Content-MD5 を付与し、ストレージが受け取った内容を検証して 2xx を返却した場合のみ、成功とする
+func (s *S3) Upload(ctx context.Context, path string) error {
+	fp, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	info, err := fp.Stat()
+	if err != nil {
+		return err
+	}
+	// 署名と Content-MD5 に使用するハッシュ値を求める
+	sh, mh := sha256.New(), md5.New()
+	if _, err := io.Copy(io.MultiWriter(sh, mh), fp); err != nil {
+		return err
+	}
+	if _, err := fp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	key := objectKey(s.Prefix, s.Key, path)
+	u, err := url.Parse(strings.TrimSuffix(s.Endpoint, "/"))
+	if err != nil {
+		return err
+	}
+	u.Path += "/" + s.Bucket + "/" + key
+	u.RawPath = escapePath(u.Path)
+	req, err := http.NewRequest("PUT", u.String(), ioutil.NopCloser(fp))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(mh.Sum(nil)))
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	s.sign(req, hex.EncodeToString(sh.Sum(nil)), now())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("upload: PUT %s: %s: %s", key, resp.Status, strings.TrimSpace(string(body)))
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
+
+// 署名するヘッダか否かを判定する
+func signedHeader(name string) bool {
+	return name == "content-md5" || name == "content-type" || name == "range" || strings.HasPrefix(name, "x-amz-")
+}
+
+// リクエストへ AWS Signature Version 4 の Authorization ヘッダを付与する
+func (s *S3) sign(req *http.Request, payload string, now time.Time) {
+	region := s.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	now = now.UTC()
+	amzdate, date := now.Format("20060102T150405Z"), now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzdate)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+
+	// 1. 正規リクエストを作成する
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		if name = strings.ToLower(name); signedHeader(name) {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonical strings.Builder
+	for _, name := range names {
+		canonical.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+	request := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonical.String(),
+		signed,
+		payload,
+	}, "\n")
+
+	// 2. 署名する文字列を作成し、日付、リージョン、サービスから導出した鍵で署名する
+	scope := date + "/" + region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(request))
+	text := "AWS4-HMAC-SHA256\n" + amzdate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+	k := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	k = hmacSHA256(k, region)
+	k = hmacSHA256(k, "s3")
+	k = hmacSHA256(k, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(k, text))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKey+"/"+scope+
+		", SignedHeaders="+signed+", Signature="+signature)
+}
+
+// クエリパラメータを、キーの順に並べてエスケープする
+func canonicalQuery(values url.Values) string {
+	var keys []string
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var params []string
+	for _, key := range keys {
+		vals := append([]string(nil), values[key]...)
+		sort.Strings(vals)
+		for _, v := range vals {
+			params = append(params, escapeQuery(key)+"="+escapeQuery(v))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// クエリパラメータを、RFC 3986 の非予約文字以外をエスケープする
+func escapeQuery(s string) string {
+	return strings.Replace(escapePath(s), "/", "%2F", -1)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
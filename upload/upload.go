@@ -0,0 +1,99 @@
+package upload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// アップロード先のキーを求める。key が nil の場合は、prefix とファイル名を連結する
+func objectKey(prefix string, key func(string) string, path string) string {
+	if key != nil {
+		return key(path)
+	}
+	return prefix + filepath.Base(path)
+}
+
+// Dir 構造体は、ログローテーションしたアーカイブを、ローカルのディレクトリ(NFS 等のマウントを含む)へコピーする構造体
+type Dir struct {
+	Path   string              // コピー先のディレクトリ
+	Prefix string              // コピー先のファイル名へ付与する文字列。"/" を含む場合はサブディレクトリとなる
+	Key    func(string) string // アーカイブのパスから、コピー先の Path からの相対パスを求める関数。nil の場合は Prefix + ファイル名
+	Perm   os.FileMode         // コピー先のファイルのパーミッション。未指定の場合は 0644
+}
+
+// Upload : アーカイブをコピーする。コピー先へ一時ファイルとして書き込み、fsync した後に置き換えるため、
+// 成功した場合は、コピー先に完全なアーカイブが存在する
+func (d *Dir) Upload(ctx context.Context, path string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dest := filepath.Join(d.Path, filepath.FromSlash(objectKey(d.Prefix, d.Key, path)))
+	dir := filepath.Dir(dest)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	perm := d.Perm
+	if perm == 0 {
+		perm = 0644
+	}
+	tmp := dest + ".tmp"
+	fp, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(fp, src)
+	if err == nil && n != info.Size() {
+		err = fmt.Errorf("upload: %s: copied %d of %d bytes", path, n, info.Size())
+	}
+	if err == nil {
+		err = fp.Sync()
+	}
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dest)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// 置き換えたファイルを、電源断後も失われないようにする
+	fd, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = fd.Sync()
+	if cerr := fd.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// URI のパスを、RFC 3986 の非予約文字と "/" 以外をエスケープする
+func escapePath(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) != -1 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}